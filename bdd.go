@@ -0,0 +1,173 @@
+package opp
+
+// bdd builds reduced ordered binary decision diagrams over condition
+// variables. Equal functions get equal node numbers, so a condition is a
+// tautology exactly if it is bddTrue and unsatisfiable if it is bddFalse.
+// Variables are ordered by first use.
+type bdd struct {
+	nodes  []bddNode
+	unique map[bddNode]int
+	vars   map[string]int
+	names  []string
+	memo   map[bddOp]int
+}
+
+// bddNode tests variable level: low is the diagram for undefined, high
+// for defined
+type bddNode struct {
+	level, low, high int
+}
+
+type bddOp struct {
+	op, x, y int
+}
+
+// The terminal nodes
+const (
+	bddFalse = 0
+	bddTrue  = 1
+)
+
+// bddTerminalLevel sorts the terminals after every variable
+const bddTerminalLevel = int(^uint(0) >> 1)
+
+const (
+	bddAnd = iota
+	bddNot
+)
+
+func newBDD() *bdd {
+	return &bdd{
+		nodes: []bddNode{
+			{level: bddTerminalLevel},
+			{level: bddTerminalLevel},
+		},
+		unique: map[bddNode]int{},
+		vars:   map[string]int{},
+		memo:   map[bddOp]int{},
+	}
+}
+
+// variable returns the diagram that is true if name is defined
+func (b *bdd) variable(name string) int {
+	level, ok := b.vars[name]
+	if !ok {
+		level = len(b.names)
+		b.vars[name] = level
+		b.names = append(b.names, name)
+	}
+	return b.node(level, bddFalse, bddTrue)
+}
+
+// node returns the unique node for the triple, skipping redundant tests
+func (b *bdd) node(level, low, high int) int {
+	if low == high {
+		return low
+	}
+	key := bddNode{level, low, high}
+	if n, ok := b.unique[key]; ok {
+		return n
+	}
+	b.nodes = append(b.nodes, key)
+	b.unique[key] = len(b.nodes) - 1
+	return len(b.nodes) - 1
+}
+
+func (b *bdd) not(x int) int {
+	switch x {
+	case bddFalse:
+		return bddTrue
+	case bddTrue:
+		return bddFalse
+	}
+	key := bddOp{bddNot, x, 0}
+	if n, ok := b.memo[key]; ok {
+		return n
+	}
+	n := b.nodes[x]
+	result := b.node(n.level, b.not(n.low), b.not(n.high))
+	b.memo[key] = result
+	return result
+}
+
+func (b *bdd) and(x, y int) int {
+	switch {
+	case x == bddFalse || y == bddFalse:
+		return bddFalse
+	case x == bddTrue || x == y:
+		return y
+	case y == bddTrue:
+		return x
+	}
+	if x > y {
+		x, y = y, x
+	}
+	key := bddOp{bddAnd, x, y}
+	if n, ok := b.memo[key]; ok {
+		return n
+	}
+	
+	nx, ny := b.nodes[x], b.nodes[y]
+	level := nx.level
+	if ny.level < level {
+		level = ny.level
+	}
+	xLow, xHigh := x, x
+	if nx.level == level {
+		xLow, xHigh = nx.low, nx.high
+	}
+	yLow, yHigh := y, y
+	if ny.level == level {
+		yLow, yHigh = ny.low, ny.high
+	}
+	result := b.node(level, b.and(xLow, yLow), b.and(xHigh, yHigh))
+	b.memo[key] = result
+	return result
+}
+
+func (b *bdd) or(x, y int) int {
+	return b.not(b.and(b.not(x), b.not(y)))
+}
+
+// condition returns the diagram for c, the NAND of its objects, or the
+// object of a single term
+func (b *bdd) condition(c *Condition) int {
+	if len(c.Terms) == 1 {
+		return b.term(c.Terms[0])
+	}
+	all := bddTrue
+	for _, t := range c.Terms {
+		all = b.and(all, b.term(t))
+	}
+	return b.not(all)
+}
+
+// term returns the diagram for the object of t
+func (b *bdd) term(t *Term) int {
+	if t.Sub != nil {
+		return b.condition(t.Sub)
+	}
+	return b.variable(t.Name)
+}
+
+// satisfy returns an assignment that makes x true, preferring undefined
+// variables. Variables x does not depend on are left out. It reports false
+// if x is unsatisfiable.
+func (b *bdd) satisfy(x int) (map[string]bool, bool) {
+	if x == bddFalse {
+		return nil, false
+	}
+	assignment := map[string]bool{}
+	for x != bddTrue {
+		n := b.nodes[x]
+		name := b.names[n.level]
+		if n.low != bddFalse {
+			assignment[name] = false
+			x = n.low
+		} else {
+			assignment[name] = true
+			x = n.high
+		}
+	}
+	return assignment, true
+}
\ No newline at end of file
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/p-nand-q/opp"
+)
+
+// runExplain implements "opp explain": it prints every condition of a file
+// in readable form, or writes a copy of the file annotated with them
+func runExplain(args []string) int {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	var (
+		annotate = flags.Bool("annotate", false, "Write a copy of the file with each condition explained in a comment")
+		output   = flags.String("o", "", "Output file for -annotate (default: stdout)")
+		lang     = flags.String("lang", "", "Target language, which decides the comment syntax (default: from the file extension)")
+		comment  = flags.String("comment", "", "Line comment marker for -annotate (default: from the language)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s explain [options] <input-file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	inputFile := flags.Arg(0)
+	
+	if !*annotate {
+		explanations, err := opp.ExplainFile(inputFile)
+		for _, e := range explanations {
+			fmt.Printf("%s: %s\n", e.Pos, e)
+		}
+		return reportDiagnostics(err)
+	}
+	
+	// Pick the comment marker
+	marker := *comment
+	if marker == "" {
+		language := opp.LanguageForFile(inputFile)
+		if *lang != "" {
+			var err error
+			if language, err = opp.ParseLanguage(*lang); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -lang option: %v\n", err)
+				return 1
+			}
+		}
+		if marker = language.LineComment; marker == "" {
+			fmt.Fprintf(os.Stderr, "%s has no line comments; use -comment\n", language.Name)
+			return 1
+		}
+	}
+	
+	in, err := os.Open(inputFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+		return 1
+	}
+	defer in.Close()
+	
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			return 1
+		}
+	}
+	writer := bufio.NewWriter(out)
+	err = opp.Annotate(in, writer, inputFile, marker)
+	if ferr := writer.Flush(); err == nil {
+		err = ferr
+	}
+	if *output != "" {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return reportDiagnostics(err)
+}
+
+// reportDiagnostics prints err and returns the exit status for it
+func reportDiagnostics(err error) int {
+	if err == nil {
+		return 0
+	}
+	if diagnostics, ok := err.(opp.Diagnostics); ok {
+		for _, d := range diagnostics {
+			fmt.Fprintln(os.Stderr, d)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return 1
+}
\ No newline at end of file
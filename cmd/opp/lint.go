@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/p-nand-q/opp"
+)
+
+// runLint implements "opp lint": it reports constant conditions, branches
+// that are never taken and unbalanced blocks in the given files
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	var warnings flagList
+	flags.Var(&warnings, "W", "Warning control: error, <name> or no-<name> (can be used multiple times)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s lint [options] <input-file>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 1
+	}
+	
+	filter := &lintFilter{disabled: map[opp.Warning]bool{}}
+	for _, option := range warnings {
+		if err := applyWarningOption(filter, option); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -W option: %v\n", err)
+			return 1
+		}
+	}
+	
+	status := 0
+	for _, inputFile := range flags.Args() {
+		diagnostics, err := opp.LintFile(inputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			status = 1
+			continue
+		}
+		for _, d := range diagnostics {
+			if filter.disabled[d.Warning] {
+				continue
+			}
+			if d.Severity == opp.SeverityWarning && filter.asErrors {
+				d.Severity = opp.SeverityError
+			}
+			if d.Severity == opp.SeverityError {
+				status = 1
+			}
+			fmt.Fprintln(os.Stderr, d)
+		}
+	}
+	return status
+}
+
+// lintFilter holds the -W options of "opp lint"
+type lintFilter struct {
+	disabled map[opp.Warning]bool
+	asErrors bool
+}
+
+func (f *lintFilter) SetWarning(w opp.Warning, enabled bool) {
+	f.disabled[w] = !enabled
+}
+
+func (f *lintFilter) SetWarningsAsErrors(on bool) {
+	f.asErrors = on
+}
\ No newline at end of file
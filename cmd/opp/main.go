@@ -1,71 +1,260 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/p-nand-q/opp"
 )
 
 func main() {
+	// Subcommands. They shadow input files of the same name, which can be
+	// given as ./name or after --.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "nand":
+			os.Exit(runNand(os.Args[2:]))
+		case "explain":
+			os.Exit(runExplain(os.Args[2:]))
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "solve":
+			os.Exit(runSolve(os.Args[2:]))
+		case "matrix":
+			os.Exit(runMatrix(os.Args[2:]))
+		}
+	}
+	
 	var (
-		output  = flag.String("o", "", "Output file (default: stdout)")
-		defines flagList
+		output    = flag.String("o", "", "Output file (default: stdout)")
+		keepGoing = flag.Bool("k", false, "Keep going after errors and report all diagnostics")
+		preserve  = flag.Bool("preserve-lines", false, "Keep removed lines as empty lines so line numbers match the input")
+		lineStyle = flag.String("line-directives", "none", "Emit line directives for the target language: go, c or none")
+		sourceMap = flag.Bool("source-map", false, "Write a source map to <output>.map (requires -o)")
+		lang      = flag.String("lang", "", "Target language: go, c, cpp, python, java, justif or plain (default: from the file extension)")
+		literals  = flag.Bool("expand-in-literals", false, "Expand macros inside strings, characters and comments too")
+		env       = flag.Bool("env", false, "Treat environment variables as defined condition variables")
+		envPrefix = flag.String("env-prefix", "", "Only use environment variables with this prefix, which is stripped: OPP_ makes OPP_DEBUG define DEBUG (implies -env)")
+		compat    = flag.String("compat", "none", "Compatibility profile: none, or original to reproduce the output of the original C++ OPP")
+		defines   []defineOption
+		warnings  flagList
 	)
 	
-	flag.Var(&defines, "D", "Define a variable (can be used multiple times)")
+	flag.Var(defineFlag{&defines, false}, "D", "NAME defines a variable for conditions, NAME=value a macro, NAME= an empty macro (can be used multiple times)")
+	flag.Var(defineFlag{&defines, true}, "U", "Undefine a variable or macro defined by an earlier -D (can be used multiple times)")
+	flag.Var(&warnings, "W", "Warning control: error, <name> or no-<name> (can be used multiple times)")
 	flag.Parse()
 	
 	if flag.NArg() < 1 {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] <input-file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s nand <expression>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s explain [-annotate] <input-file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s lint [-W ...] <input-file>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s solve <input-file>:<line>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s matrix [-vars A,B] [-combo A,B] <input-file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "An input file named like a subcommand must be given as ./name or after --.\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 	
 	inputFile := flag.Arg(0)
 	
-	// Read input file
-	input, err := os.ReadFile(inputFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+	if *sourceMap && *output == "" {
+		fmt.Fprintf(os.Stderr, "-source-map requires -o\n")
 		os.Exit(1)
 	}
 	
 	// Create preprocessor
 	preprocessor := opp.New()
+	preprocessor.SetKeepGoing(*keepGoing)
+	preprocessor.SetPreserveLines(*preserve)
+	
+	style, err := opp.ParseLineDirectiveStyle(*lineStyle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -line-directives option: %v\n", err)
+		os.Exit(1)
+	}
+	preprocessor.SetLineDirectives(style)
+	
+	profile, err := opp.ParseCompat(*compat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -compat option: %v\n", err)
+		os.Exit(1)
+	}
+	preprocessor.SetCompat(profile)
+	
+	if *lang != "" {
+		language, err := opp.ParseLanguage(*lang)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -lang option: %v\n", err)
+			os.Exit(1)
+		}
+		preprocessor.SetLanguage(language)
+	}
+	preprocessor.SetExpandInLiterals(*literals)
+	if *env || *envPrefix != "" {
+		preprocessor.SetEnvironment(*envPrefix, os.LookupEnv)
+	}
+	
+	// Apply warning options
+	for _, w := range warnings {
+		if err := applyWarningOption(preprocessor, w); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -W option: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	
 	// Apply command-line defines
-	for _, def := range defines {
-		parts := strings.SplitN(def, "=", 2)
-		if len(parts) == 2 {
-			preprocessor.Define(parts[0], parts[1])
-		} else {
-			preprocessor.Define(parts[0], "1")
+	applyDefines(preprocessor, defines)
+	
+	// Open the output; without a source map it is streamed line by line.
+	// A file is removed on error, but stdout keeps what was written: the
+	// output up to the error, or everything with -k.
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			os.Exit(1)
 		}
 	}
+	writer := bufio.NewWriter(out)
 	
 	// Process the input
-	result, err := preprocessor.Process(string(input))
+	var sm *opp.SourceMap
+	if *sourceMap {
+		var result string
+		result, sm, err = preprocessor.ProcessFileWithSourceMap(inputFile)
+		if err == nil {
+			_, err = writer.WriteString(result)
+		}
+	} else {
+		err = preprocessor.ProcessFileWriter(inputFile, writer)
+	}
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	
+	if diagnostics, ok := err.(opp.Diagnostics); ok {
+		for _, d := range diagnostics {
+			fmt.Fprintln(os.Stderr, d)
+		}
+	} else {
+		for _, w := range preprocessor.Warnings() {
+			fmt.Fprintln(os.Stderr, w)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Preprocessing error: %v\n", err)
+		}
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Preprocessing error: %v\n", err)
+		if *output != "" {
+			out.Close()
+			os.Remove(*output)
+		}
 		os.Exit(1)
 	}
 	
-	// Write output
 	if *output != "" {
-		err = os.WriteFile(*output, []byte(result), 0644)
-		if err != nil {
+		if err := out.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
 			os.Exit(1)
 		}
-	} else {
-		fmt.Print(result)
+	}
+	
+	// Write the source map next to the output
+	if *sourceMap {
+		sm.File = filepath.Base(*output)
+		data, err := json.Marshal(sm)
+		if err == nil {
+			err = os.WriteFile(*output+".map", data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing source map: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// warningSettings is what -W controls: a Preprocessor, or the filter of
+// "opp lint"
+type warningSettings interface {
+	SetWarning(w opp.Warning, enabled bool)
+	SetWarningsAsErrors(on bool)
+}
+
+// applyWarningOption handles one -W value
+func applyWarningOption(p warningSettings, option string) error {
+	switch option {
+	case "error":
+		p.SetWarningsAsErrors(true)
+		return nil
+	case "no-error":
+		p.SetWarningsAsErrors(false)
+		return nil
+	}
+	
+	enabled := !strings.HasPrefix(option, "no-")
+	w, err := opp.ParseWarning(strings.TrimPrefix(option, "no-"))
+	if err != nil {
+		return err
+	}
+	p.SetWarning(w, enabled)
+	return nil
+}
+
+// defineOption is a -D or -U option
+type defineOption struct {
+	undefine bool
+	value    string
+}
+
+// defineFlag collects -D and -U options in command-line order, so a later
+// option overrides an earlier one
+type defineFlag struct {
+	options  *[]defineOption
+	undefine bool
+}
+
+func (f defineFlag) String() string {
+	return ""
+}
+
+func (f defineFlag) Set(value string) error {
+	if value == "" || strings.HasPrefix(value, "=") {
+		return fmt.Errorf("missing name")
+	}
+	if f.undefine && strings.Contains(value, "=") {
+		return fmt.Errorf("-U takes a name, not a value")
+	}
+	*f.options = append(*f.options, defineOption{undefine: f.undefine, value: value})
+	return nil
+}
+
+// applyDefines applies -D and -U options. -D NAME defines a variable, which
+// conditions see but the text does not; -D NAME=value defines a macro that
+// replaces NAME with value, and -D NAME= one that removes NAME. -U NAME
+// undefines both.
+func applyDefines(p *opp.Preprocessor, options []defineOption) {
+	for _, option := range options {
+		name, value, isMacro := strings.Cut(option.value, "=")
+		switch {
+		case option.undefine:
+			p.Undefine(option.value)
+		case isMacro:
+			p.DefineMacro(name, value)
+		default:
+			p.DefineVariable(name)
+		}
 	}
 }
 
-// flagList allows multiple -D flags
+// flagList collects a repeatable option
 type flagList []string
 
 func (f *flagList) String() string {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/p-nand-q/opp"
+)
+
+// maxMatrixVariables bounds the full matrix to 65536 runs
+const maxMatrixVariables = 16
+
+// runMatrix implements "opp matrix": it preprocesses a file under every
+// combination of its condition variables and reports failures and
+// duplicate outputs
+func runMatrix(args []string) int {
+	flags := flag.NewFlagSet("matrix", flag.ExitOnError)
+	var (
+		vars     = flags.String("vars", "", "Comma-separated variables to combine (default: all variables tested by the file's conditions)")
+		jobs     = flags.Int("j", 0, "Number of combinations processed in parallel (default: number of CPUs)")
+		lang     = flags.String("lang", "", "Target language (default: from the file extension)")
+		literals = flags.Bool("expand-in-literals", false, "Expand macros inside strings, characters and comments too")
+		combos   flagList
+		defines  []defineOption
+	)
+	flags.Var(&combos, "combo", "Run only this comma-separated combination of defined variables (can be used multiple times)")
+	flags.Var(defineFlag{&defines, false}, "D", "Define a variable (NAME) or macro (NAME=value) in every combination (can be used multiple times)")
+	flags.Var(defineFlag{&defines, true}, "U", "Undefine a variable or macro in every combination (can be used multiple times)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s matrix [options] <input-file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	inputFile := flags.Arg(0)
+	
+	var language *opp.Language
+	if *lang != "" {
+		var err error
+		if language, err = opp.ParseLanguage(*lang); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -lang option: %v\n", err)
+			return 1
+		}
+	}
+	
+	// Pick the combinations
+	var combinations [][]string
+	for _, combo := range combos {
+		combinations = append(combinations, splitList(combo))
+	}
+	if combinations == nil {
+		variables := splitList(*vars)
+		if *vars == "" {
+			in, err := os.Open(inputFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+				return 1
+			}
+			variables, err = opp.ConditionVariables(in, inputFile)
+			in.Close()
+			if err != nil {
+				return reportDiagnostics(err)
+			}
+		}
+		if len(variables) > maxMatrixVariables {
+			fmt.Fprintf(os.Stderr, "Too many variables (%d) for a full matrix; pick some with -vars or -combo\n", len(variables))
+			return 1
+		}
+		combinations = opp.Combinations(variables)
+	}
+	
+	newPreprocessor := func() *opp.Preprocessor {
+		p := opp.New()
+		if language != nil {
+			p.SetLanguage(language)
+		}
+		p.SetExpandInLiterals(*literals)
+		applyDefines(p, defines)
+		return p
+	}
+	runs := opp.RunMatrix(context.Background(), inputFile, combinations, *jobs, false, newPreprocessor)
+	
+	failed, distinct := 0, 0
+	for _, run := range runs {
+		fmt.Printf("%s: ", combinationName(run.Defined))
+		switch {
+		case run.Err != nil:
+			failed++
+			fmt.Printf("error: %s\n", strings.ReplaceAll(run.Err.Error(), "\n", "\n    "))
+		case run.SameAs >= 0:
+			fmt.Printf("same output as %s\n", combinationName(runs[run.SameAs].Defined))
+		default:
+			distinct++
+			fmt.Printf("ok\n")
+		}
+	}
+	fmt.Printf("%d combinations, %d failed, %d distinct outputs\n", len(runs), failed, distinct)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// combinationName formats the defined variables of a combination
+func combinationName(defined []string) string {
+	if len(defined) == 0 {
+		return "(none)"
+	}
+	return strings.Join(defined, " ")
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	names := []string{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
\ No newline at end of file
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/p-nand-q/opp"
+)
+
+// runNand implements "opp nand": it prints the OPP condition for a boolean
+// expression
+func runNand(args []string) int {
+	flags := flag.NewFlagSet("nand", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s nand <expression>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Prints the ##~ condition for an expression like 'A && !B || defined(C)'\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 1
+	}
+	
+	expr := strings.Join(flags.Args(), " ")
+	cond, err := opp.CompileCondition(expr)
+	if err != nil {
+		if serr, ok := err.(*opp.SyntaxError); ok {
+			// The offset counts bytes, the caret is placed in characters
+			fmt.Fprintf(os.Stderr, "%s\n%*s^ %v\n", expr, utf8.RuneCountInString(expr[:serr.Offset]), "", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Invalid expression: %v\n", err)
+		}
+		return 1
+	}
+	fmt.Println(cond)
+	return 0
+}
\ No newline at end of file
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/p-nand-q/opp"
+)
+
+// runSolve implements "opp solve": it prints which variables make a line
+// of a file appear in the output
+func runSolve(args []string) int {
+	flags := flag.NewFlagSet("solve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s solve <input-file>:<line>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Prints the -D options that make the line appear in the output\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	
+	target := flags.Arg(0)
+	colon := strings.LastIndex(target, ":")
+	line, err := strconv.Atoi(target[colon+1:])
+	if colon < 0 || err != nil || line < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid target %q: expected <input-file>:<line>\n", target)
+		return 1
+	}
+	inputFile := target[:colon]
+	
+	solution, err := opp.SolveFile(inputFile, line)
+	if err != nil {
+		return reportDiagnostics(err)
+	}
+	if !solution.Satisfiable {
+		fmt.Printf("%s: never appears: no combination of variables satisfies the enclosing conditions\n", solution.Pos)
+		return 1
+	}
+	if len(solution.Defined) == 0 && len(solution.Undefined) == 0 {
+		fmt.Printf("%s: always appears\n", solution.Pos)
+		return 0
+	}
+	
+	fmt.Printf("%s: appears if %s\n", solution.Pos, solution.Condition)
+	if len(solution.Defined) > 0 {
+		fmt.Printf("  define:          %s\n", strings.Join(solution.Defined, " "))
+	}
+	if len(solution.Undefined) > 0 {
+		fmt.Printf("  leave undefined: %s\n", strings.Join(solution.Undefined, " "))
+	}
+	command := os.Args[0]
+	for _, name := range solution.Defined {
+		command += " -D " + name
+	}
+	fmt.Printf("  for example:     %s %s\n", command, inputFile)
+	return 0
+}
\ No newline at end of file
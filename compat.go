@@ -0,0 +1,521 @@
+package opp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Compat selects a compatibility profile for Process
+type Compat int
+
+const (
+	// CompatNone is the documented OPP language
+	CompatNone Compat = iota
+	// CompatOriginal reproduces the original C++ OPP.exe in archive/opp,
+	// bugs included; see archive/DIFFERENCES.md
+	CompatOriginal
+)
+
+var compatNames = map[Compat]string{
+	CompatNone:     "none",
+	CompatOriginal: "original",
+}
+
+func (c Compat) String() string {
+	if name, ok := compatNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Compat(%d)", int(c))
+}
+
+// ParseCompat returns the profile with the given name: none or original
+func ParseCompat(name string) (Compat, error) {
+	for c, n := range compatNames {
+		if n == name {
+			return c, nil
+		}
+	}
+	return CompatNone, fmt.Errorf("unknown compatibility profile %q", name)
+}
+
+// SetCompat selects a compatibility profile. With CompatOriginal, Process
+// works like the original OPP.exe, so legacy sources produce the same bytes:
+//
+//   - conditions are exactly two terms joined by a dot, ~a.~b, and are true
+//     if both a and b are false, the AND of the negated terms
+//   - variables come only from the environment, see SetEnvironment, which
+//     defaults to os.LookupEnv here; a value starting with 0 or equal to
+//     FALSE counts as undefined
+//   - blocks are a skip counter rather than a stack: a true ##~ does not
+//     count, ##. and ##@ decrement it and ##@ needs a condition
+//   - directives may appear anywhere in a line and are handled even in
+//     skipped blocks
+//   - macros only expand when followed by (, at any position, the first
+//     definition of a name wins and the line is scanned again after every
+//     expansion
+//   - ##i is complex(0,1), ##_ counts lines across includes, ##{ counts
+//     both { and } and ##} is always 0
+//   - every line, directives and skipped ones included, ends with a newline,
+//     and the last line of an included file is written twice, or a newline
+//     for an empty one
+//
+// The first error stops processing, as keep-going mode did not exist, and
+// ##$ uses the deterministic generator instead of rand(). Line directives,
+// source maps and line preservation do not apply.
+func (p *Preprocessor) SetCompat(c Compat) {
+	p.compat = c
+}
+
+// originalMaxLine is MAX_COLUMNS_PER_LINE: fgets splits longer lines
+const originalMaxLine = 10240
+
+// original holds the state of the C++ OPP that outlives a single line
+type original struct {
+	// macros are in order of definition
+	macros []*Macro
+	// skip is m_iSkipIndex: lines are skipped while it is positive
+	skip int
+	// line and braces are m_nLineNumber and m_nCurlyBracketsOpen, reset
+	// whenever a file is opened
+	line, braces int
+	// out is m_szOutput; it is reused for every line and its string ends at
+	// the first 0
+	out cBuffer
+}
+
+// cBuffer emulates a C char array
+type cBuffer []byte
+
+// put writes text at pos without a terminating 0 and returns the position
+// after it
+func (b *cBuffer) put(pos int, text string) int {
+	for len(*b) < pos+len(text)+1 {
+		*b = append(*b, 0)
+	}
+	copy((*b)[pos:], text)
+	return pos + len(text)
+}
+
+// String returns the C string in b
+func (b cBuffer) String() string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
+
+// processOriginal is process for CompatOriginal
+func (p *Preprocessor) processOriginal(r io.Reader) error {
+	o := &original{}
+	
+	// Macros defined through the API come first, in a stable order
+	var names []string
+	for name, m := range p.macros {
+		if !m.predefined {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o.macros = append(o.macros, p.macros[name])
+	}
+	
+	if err := p.processOriginalFile(o, r); err != nil {
+		return err
+	}
+	if p.diagnostics.HasErrors() {
+		return p.diagnostics
+	}
+	return nil
+}
+
+// processOriginalFile is OPP::IProcessFile
+func (p *Preprocessor) processOriginalFile(o *original, r io.Reader) error {
+	o.line, o.braces = 0, 0
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		p.lineNumber = lineNumber
+		if err := p.checkContext(); err != nil {
+			return err
+		}
+		
+		// fgets reads up to and including a newline, or one byte less
+		// than the buffer
+		var line []byte
+		for len(line) < originalMaxLine-1 {
+			c, err := reader.ReadByte()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return p.errorf(DirectiveNone, -1, "cannot read input: %w", err)
+			}
+			line = append(line, c)
+			if c == '\n' {
+				break
+			}
+		}
+		if len(line) == 0 {
+			return nil
+		}
+		o.line++
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		
+		if err := p.processOriginalLine(o, cBuffer(line).String()); err != nil {
+			return err
+		}
+		if err := p.out.write(o.out.String()); err != nil {
+			return p.wrapError(DirectiveNone, -1, err)
+		}
+	}
+}
+
+// processOriginalLine is OPP::ProcessSingleLine: it leaves the output of
+// line in o.out
+func (p *Preprocessor) processOriginalLine(o *original, line string) error {
+	input := line
+	expansions := 0
+	// column returns the 1-based column of i while it is still one in the
+	// source line
+	column := func(i int) int {
+		if expansions > 0 {
+			return -1
+		}
+		return i
+	}
+	
+	for {
+		write := 0
+		expanded := false
+		for i := 0; i < len(input); {
+			if strings.HasPrefix(input[i:], "##") {
+				at, c := i, byte(0)
+				if i+2 < len(input) {
+					c = input[i+2]
+				}
+				switch c {
+				case 'i':
+					write = o.out.put(write, "complex(0,1)")
+					i += 3
+				case '_':
+					write = o.out.put(write, strconv.Itoa(o.line-5))
+					i += 3
+				case '$':
+					write = o.out.put(write, strconv.Itoa(p.random.Next()))
+					i += 3
+				case '{':
+					write = o.out.put(write, strconv.Itoa(o.braces))
+					i += 3
+				case '}':
+					// m_nCurlyBracketsClose is never incremented
+					write = o.out.put(write, "0")
+					i += 3
+				case '.':
+					if o.skip > 0 {
+						o.skip--
+					}
+					i += 3
+				case ':':
+					definition := input[i+3:]
+					space := strings.IndexByte(definition, ' ')
+					if space < 0 {
+						return p.errorf(DirectiveDefine, column(i), "invalid syntax for macro %s", definition)
+					}
+					o.macros = append(o.macros, &Macro{Name: definition[:space], Definition: definition[space+1:]})
+					i = len(input)
+				case '@', '~':
+					if c == '@' {
+						if o.skip > 0 {
+							o.skip--
+						}
+						i++
+					}
+					cond, n, ok := parseOriginalCondition(input[i+2:])
+					if !ok {
+						kind := DirectiveCondition
+						if c == '@' {
+							kind = DirectiveElse
+						}
+						return p.errorf(kind, column(at), "invalid syntax for conditional compilation statement %s", input[i+2:])
+					}
+					if !p.evalOriginal(cond) {
+						o.skip++
+					}
+					i += 2 + n
+				case '<':
+					o.out.put(write, "\n")
+					return p.includeOriginal(o, input[i+3:], column(i))
+				default:
+					return p.errorf(DirectiveUnknown, column(i), "unknown preprocessor directive %q", input[i:])
+				}
+				continue
+			}
+			if o.skip > 0 {
+				i++
+				continue
+			}
+			
+			next, text, ok, err := p.expandOriginalMacro(o, input, i, write)
+			if err != nil {
+				return err
+			}
+			if ok {
+				write = o.out.put(write, text)
+				expanded = true
+				i = next
+				continue
+			}
+			// A call with missing arguments is dropped
+			if i = next; i >= len(input) {
+				break
+			}
+			if c := input[i]; c == '{' || c == '}' {
+				o.braces++
+			}
+			write = o.out.put(write, input[i:i+1])
+			i++
+		}
+		
+		if !expanded {
+			o.out.put(o.out.put(write, "\n"), "\x00")
+			return nil
+		}
+		// Scan the expanded line again
+		expansions++
+		if max := p.limits.MaxExpansions; max > 0 && expansions > max {
+			return p.limitError(DirectiveNone, -1, LimitExpansions, int64(max))
+		}
+		input = string(o.out[:write])
+	}
+}
+
+// expandOriginalMacro is OPP::IsAMacro for the macro call at input[i]: the
+// first macro whose name is followed by ( is called. It returns the position
+// after the call and the expansion. A call with missing arguments is
+// consumed but reported as not expanded, as in the original.
+func (p *Preprocessor) expandOriginalMacro(o *original, input string, i, write int) (int, string, bool, error) {
+	for _, m := range o.macros {
+		if !strings.HasPrefix(input[i:], m.Name+"(") {
+			continue
+		}
+		
+		// OPPM::Fits splits the arguments at top-level commas
+		var args []string
+		r := i + len(m.Name) + 1
+		start, level := r, 0
+		for ; r < len(input) && (input[r] != ')' || level > 0); r++ {
+			switch {
+			case input[r] == '(':
+				level++
+			case input[r] == ')':
+				level--
+			case input[r] == ',' && level == 0:
+				args = append(args, input[start:r])
+				start = r + 1
+			}
+		}
+		args = append(args, input[start:r])
+		next := r + 1
+		if next > len(input) {
+			next = len(input)
+		}
+		
+		text, missing := substituteOriginal(m.Definition, args)
+		if missing >= 0 {
+			// The partial expansion stays in the buffer
+			o.out.put(write, text)
+			err := p.warn(WarnMissingArguments, p.errorf(DirectiveNone, -1,
+				"macro %s called with %d arguments but uses #%d", m.Name, len(args), missing))
+			return next, "", false, err
+		}
+		return next, text, true, nil
+	}
+	return i, "", false, nil
+}
+
+// substituteOriginal is OPPM::Parse: #N inserts argument N, #N..n the
+// arguments from N on joined by commas, #" and #' quote the next argument
+// and ##,# writes a # that survives into the expansion. If an argument is
+// missing, it returns the expansion so far and the index of the argument,
+// otherwise -1.
+func substituteOriginal(definition string, args []string) (string, int) {
+	var result strings.Builder
+	quote := ""
+	for d := 0; d < len(definition); {
+		switch {
+		case strings.HasPrefix(definition[d:], "#\""):
+			d += 2
+			quote = "\""
+		case strings.HasPrefix(definition[d:], "#'"):
+			d += 2
+			quote = "'"
+		case strings.HasPrefix(definition[d:], "##,#"):
+			d += 4
+			result.WriteByte('#')
+			if d < len(definition) && definition[d] == '#' {
+				result.WriteByte('#')
+				d++
+			}
+			if d < len(definition) && isDigit(definition[d]) {
+				for d < len(definition) && isDigit(definition[d]) {
+					result.WriteByte(definition[d])
+					d++
+				}
+			} else if d < len(definition) {
+				result.WriteByte(definition[d])
+				d++
+			}
+		case definition[d] == '#' && d+1 < len(definition) && isDigit(definition[d+1]):
+			d++
+			end := d
+			for end < len(definition) && isDigit(definition[end]) {
+				end++
+			}
+			n, err := strconv.Atoi(definition[d:end])
+			d = end
+			if err != nil || n >= len(args) {
+				return result.String(), n
+			}
+			last := n
+			if strings.HasPrefix(definition[d:], "..n") {
+				d += 3
+				last = len(args) - 1
+			}
+			for j := n; j <= last; j++ {
+				if j > n {
+					result.WriteByte(',')
+				}
+				result.WriteString(quote + args[j] + quote)
+			}
+			quote = ""
+		default:
+			result.WriteByte(definition[d])
+			d++
+		}
+	}
+	return result.String(), -1
+}
+
+// includeOriginal processes the file named at the start of text, in the
+// escaped form of ExtractIncludeFileName. The included file restarts the
+// line and brace counts, and the includer continues from where it left them.
+// The includer then writes whatever the output buffer holds: the last line
+// of the included file.
+func (p *Preprocessor) includeOriginal(o *original, text string, column int) error {
+	var name strings.Builder
+	for i := 0; ; {
+		switch {
+		case i >= len(text):
+			return p.errorf(DirectiveInclude, column, "invalid include syntax: missing . after the file name")
+		case strings.HasPrefix(text[i:], "\\\\"):
+			name.WriteString("..")
+			i += 2
+		case strings.HasPrefix(text[i:], "//"):
+			name.WriteString("\\\\")
+			i += 2
+		case strings.HasPrefix(text[i:], ".."):
+			name.WriteString("\\")
+			i += 2
+		case strings.HasPrefix(text[i:], "\\."):
+			name.WriteString(".")
+			i += 2
+		case text[i] == '.':
+			// The name uses Windows separators
+			filename := filepath.FromSlash(strings.ReplaceAll(name.String(), "\\", "/"))
+			return p.includeOriginalFile(o, filename, column)
+		default:
+			name.WriteByte(text[i])
+			i++
+		}
+	}
+}
+
+// includeOriginalFile opens filename like processInclude and runs it
+// through processOriginalFile
+func (p *Preprocessor) includeOriginalFile(o *original, filename string, column int) error {
+	if limit := p.limits.MaxIncludeDepth; limit > 0 && len(p.includeStack) >= limit {
+		return p.limitError(DirectiveInclude, column, LimitIncludeDepth, int64(limit))
+	}
+	f, err := os.Open(filename)
+	if err != nil && p.currentFile != "" && !filepath.IsAbs(filename) {
+		filename = filepath.Join(filepath.Dir(p.currentFile), filename)
+		f, err = os.Open(filename)
+	}
+	if err != nil {
+		return p.errorf(DirectiveInclude, column, "cannot read file %s: %w", filename, err)
+	}
+	defer f.Close()
+	
+	savedFile, savedLine := p.currentFile, p.lineNumber
+	p.includeStack = append(p.includeStack, p.position(column))
+	p.currentFile = filename
+	err = p.processOriginalFile(o, f)
+	p.includeStack = p.includeStack[:len(p.includeStack)-1]
+	p.currentFile, p.lineNumber = savedFile, savedLine
+	return err
+}
+
+// originalCondition is an OPPCCE: a variable, or two conditions a and b
+type originalCondition struct {
+	variable string
+	a, b     *originalCondition
+}
+
+// parseOriginalCondition is OPP::CheckCC: it parses ~OBJECT.~OBJECT at the
+// start of text and returns the number of bytes used
+func parseOriginalCondition(text string) (*originalCondition, int, bool) {
+	if !strings.HasPrefix(text, "~") {
+		return nil, 0, false
+	}
+	a, n, ok := parseOriginalObject(text[1:])
+	pos := 1 + n
+	if !ok || !strings.HasPrefix(text[pos:], ".~") {
+		return nil, 0, false
+	}
+	pos += 2
+	b, n, ok := parseOriginalObject(text[pos:])
+	if !ok {
+		return nil, 0, false
+	}
+	return &originalCondition{a: a, b: b}, pos + n, true
+}
+
+// parseOriginalObject is OPP::CCObject: a parenthesized condition, or a
+// variable that runs up to the next dot or )
+func parseOriginalObject(text string) (*originalCondition, int, bool) {
+	if strings.HasPrefix(text, "(") {
+		c, n, ok := parseOriginalCondition(text[1:])
+		if !ok || !strings.HasPrefix(text[1+n:], ")") {
+			return nil, 0, false
+		}
+		return c, n + 2, true
+	}
+	end := strings.IndexAny(text, ".)")
+	if end < 0 {
+		end = len(text)
+	}
+	return &originalCondition{variable: text[:end]}, end, true
+}
+
+// evalOriginal is OPPCCE::Evaluate
+func (p *Preprocessor) evalOriginal(c *originalCondition) bool {
+	if c.a != nil {
+		return !p.evalOriginal(c.a) && !p.evalOriginal(c.b)
+	}
+	if c.variable == "" {
+		return false
+	}
+	lookup := p.lookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	value, ok := lookup(p.envPrefix + c.variable)
+	return ok && (value == "" || value[0] != '0') && !strings.EqualFold(value, "FALSE")
+}
\ No newline at end of file
@@ -0,0 +1,178 @@
+package opp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The expected outputs follow archive/opp/OPP.cpp step by step
+func TestCompatOriginal(t *testing.T) {
+	// never and always are conditions that are false and true while A is
+	// undefined, without the ~ that ##~ shares with them
+	const never = "(~A.~A).~(~A.~A)"
+	const always = "A.~A"
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		input    string
+		expected string
+	}{
+		{"every line ends with a newline", nil, "a\nb", "a\nb\n"},
+		{"no extra final line", nil, "a\n", "a\n"},
+		{"empty input", nil, "", ""},
+		{"condition is the AND of negated terms", nil, "##~FOO.~BAR\nx\n##.\ny", "\nx\n\ny\n"},
+		{"one term defined", map[string]string{"FOO": "1"}, "##~FOO.~BAR\nx\n##.\ny", "\n\n\ny\n"},
+		{"empty value is defined", map[string]string{"FOO": ""}, "##~FOO.~BAR\nx\n##.\ny", "\n\n\ny\n"},
+		{"zero is undefined", map[string]string{"FOO": "0"}, "##~FOO.~BAR\nx\n##.\ny", "\nx\n\ny\n"},
+		{"false is undefined", map[string]string{"FOO": "false"}, "##~FOO.~BAR\nx\n##.\ny", "\nx\n\ny\n"},
+		{"true block does not count", nil, "##~" + never + "\n##~" + always + "\nin\n##.\nout\n##.\nend", "\n\n\n\nout\n\nend\n"},
+		{"else after true block", nil, "##~" + always + "\na\n##@~" + never + "\nb\n##.\nc", "\na\n\n\n\nc\n"},
+		{"else after false block", nil, "##~" + never + "\na\n##@~" + always + "\nb\n##.", "\n\n\nb\n\n"},
+		{"directives in skipped blocks", nil, "##~" + never + "\n##i\n##.", "\ncomplex(0,1)\n\n"},
+		{"directive inside a line", nil, "x ##i y", "x complex(0,1) y\n"},
+		{"line number offset", nil, "a\n##_", "a\n-3\n"},
+		{"both braces count as open", nil, "{ }\n##{ ##}", "{ }\n2 0\n"},
+		{"braces count again after expansion", nil, "##:F x\n{ F()\n##{", "\n{ x\n2\n"},
+		{"macros need parentheses", nil, "##:SQ #0*#0\nSQ(3) SQ", "\n3*3 SQ\n"},
+		{"first definition wins", nil, "##:F a\n##:F b\nF()", "\n\na\n"},
+		{"no identifier boundaries", nil, "##:F x\nGF()", "\nGx\n"},
+		{"stringize", nil, "##:S #\"#0\nS(hi)", "\n\"hi\"\n"},
+		{"charize", nil, "##:C #'#0\nC(a)", "\n'a'\n"},
+		{"remaining arguments", nil, "##:L f(#1..n)\nL(a,b,c)", "\nf(b,c)\n"},
+		{"nested parentheses", nil, "##:M [#0]\nM(f(a,b))", "\n[f(a,b)]\n"},
+		{"escaped directive", nil, "##:D ##,##:G ##,#0\nD(x)\nG(y)", "\n\ny\n"},
+		{"expansions are scanned again", nil, "##:A B()\n##:B b\nA()", "\n\nb\n"},
+		{"missing argument drops the call", nil, "##:F #1\nF(a)x", "\nx\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.SetCompat(CompatOriginal)
+			p.SetEnvironment("", func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			})
+			result, err := p.Process(tt.input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompatOriginalMacros(t *testing.T) {
+	p := New()
+	p.SetCompat(CompatOriginal)
+	p.DefineMacro("X", "1")
+	result, err := p.Process("X() X\n##:F #1\nF(a)")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if expected := "1 X\n\n\n"; result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+	if warnings := p.Warnings(); len(warnings) != 1 || warnings[0].Warning != WarnMissingArguments {
+		t.Errorf("Warnings() = %v, want one missing-args warning", warnings)
+	}
+}
+
+func TestCompatOriginalEnvironment(t *testing.T) {
+	t.Setenv("OPP_COMPAT_TEST", "1")
+	p := New()
+	p.SetCompat(CompatOriginal)
+	result, err := p.Process("##~OPP_COMPAT_TEST.~OPP_COMPAT_TEST\nx\n##.")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if expected := "\n\n\n"; result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+}
+
+func TestCompatOriginalInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"three.h": "h1\nh2\nh3",
+		"empty.h": "",
+		// The include continues the line count of three.h
+		"main.c":  "a\n##<three\\.h. lost\n##_\nb",
+		"empty.c": "a\n##<empty\\.h.\nb",
+		"bad.c":   "a\n##<missing\\.h.\nb",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file     string
+		expected string
+	}{
+		{"main.c", "a\nh1\nh2\nh3\nh3\n-1\nb\n"},
+		{"empty.c", "a\n\n\nb\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			p := New()
+			p.SetCompat(CompatOriginal)
+			result, err := p.ProcessFile(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatalf("ProcessFile() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("ProcessFile() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+
+	p := New()
+	p.SetCompat(CompatOriginal)
+	_, err := p.ProcessFile(filepath.Join(dir, "bad.c"))
+	var e *Error
+	if !errors.As(err, &e) || e.Directive != DirectiveInclude || e.Pos.Line != 2 {
+		t.Errorf("ProcessFile() error = %v, want an ##< error in line 2", err)
+	}
+}
+
+func TestCompatOriginalErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		directive DirectiveKind
+	}{
+		{"single term", "##~A", DirectiveCondition},
+		{"else without condition", "##@", DirectiveElse},
+		{"unknown directive", "x ##?", DirectiveUnknown},
+		{"directive at end of line", "x ##", DirectiveUnknown},
+		{"define without definition", "##:X", DirectiveDefine},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.SetCompat(CompatOriginal)
+			_, err := p.Process(tt.input)
+			var e *Error
+			if !errors.As(err, &e) || e.Directive != tt.directive {
+				t.Errorf("Process() error = %v, want a %v error", err, tt.directive)
+			}
+		})
+	}
+}
+
+func TestParseCompat(t *testing.T) {
+	for _, c := range []Compat{CompatNone, CompatOriginal} {
+		if parsed, err := ParseCompat(c.String()); err != nil || parsed != c {
+			t.Errorf("ParseCompat(%q) = %v, %v", c.String(), parsed, err)
+		}
+	}
+	if _, err := ParseCompat("c++"); err == nil {
+		t.Error("ParseCompat(\"c++\") succeeded")
+	}
+}
\ No newline at end of file
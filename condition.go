@@ -0,0 +1,212 @@
+package opp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Condition is a parsed ##~ or ##@ condition. Its grammar is
+//
+//	CONDITION = TERM { '|' TERM } .
+//	TERM      = '~' OBJECT .
+//	OBJECT    = VARIABLE | '(' CONDITION ')' .
+//
+// A condition is the NAND of the objects of its terms: it is true unless
+// every object is true. So ~a|~b is true if a or b is undefined and ~a|~b|~c
+// if any of the three is. A condition with a single term has always meant
+// its object instead, so a lone ~a is true if a is defined and ~(~a|~b) is
+// ~a|~b. Whitespace is allowed around | and parentheses but not inside a
+// term.
+type Condition struct {
+	Terms []*Term
+}
+
+// Term is one ~ of a condition. Offsets are byte offsets in the parsed text.
+type Term struct {
+	// Offset is the position of the ~
+	Offset int
+	// Name is the variable tested by the term, empty if Sub is set
+	Name string
+	// Sub is the parenthesized condition tested by the term
+	Sub *Condition
+}
+
+// SyntaxError reports malformed condition text
+type SyntaxError struct {
+	// Offset is the byte offset of the problem in the parsed text
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Msg
+}
+
+// ParseCondition parses the text after ##~ or ##@
+func ParseCondition(text string) (*Condition, error) {
+	c := &conditionParser{text: text}
+	cond, err := c.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	c.skipSpace()
+	if c.pos < len(c.text) {
+		if c.text[c.pos] == ')' {
+			return nil, c.errorf("unexpected )")
+		}
+		return nil, c.errorf("expected | or end of condition, found %q", c.found())
+	}
+	return cond, nil
+}
+
+// Eval evaluates the condition. defined is called for every variable, even
+// where the result is already known, so it can record which ones were used.
+func (c *Condition) Eval(defined func(name string) bool) bool {
+	if len(c.Terms) == 1 {
+		return c.Terms[0].eval(defined)
+	}
+	all := true
+	for _, t := range c.Terms {
+		all = t.eval(defined) && all
+	}
+	return !all
+}
+
+// eval evaluates the object of t
+func (t *Term) eval(defined func(name string) bool) bool {
+	if t.Sub != nil {
+		return t.Sub.Eval(defined)
+	}
+	return defined(t.Name)
+}
+
+// Variables returns the names tested by the condition in order of first
+// appearance
+func (c *Condition) Variables() []string {
+	var names []string
+	seen := map[string]bool{}
+	var walk func(*Condition)
+	walk = func(c *Condition) {
+		for _, t := range c.Terms {
+			if t.Sub != nil {
+				walk(t.Sub)
+			} else if !seen[t.Name] {
+				seen[t.Name] = true
+				names = append(names, t.Name)
+			}
+		}
+	}
+	walk(c)
+	return names
+}
+
+// String formats the condition in its canonical form, without whitespace
+func (c *Condition) String() string {
+	terms := make([]string, len(c.Terms))
+	for i, t := range c.Terms {
+		terms[i] = t.String()
+	}
+	return strings.Join(terms, "|")
+}
+
+func (t *Term) String() string {
+	if t.Sub != nil {
+		return "~(" + t.Sub.String() + ")"
+	}
+	return "~" + t.Name
+}
+
+type conditionParser struct {
+	text string
+	pos  int
+}
+
+func (c *conditionParser) parseCondition() (*Condition, error) {
+	cond := &Condition{}
+	for {
+		term, err := c.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		cond.Terms = append(cond.Terms, term)
+		
+		c.skipSpace()
+		if c.pos >= len(c.text) || c.text[c.pos] != '|' {
+			return cond, nil
+		}
+		c.pos++
+	}
+}
+
+func (c *conditionParser) parseTerm() (*Term, error) {
+	c.skipSpace()
+	if c.pos >= len(c.text) {
+		if c.pos == 0 {
+			return nil, c.errorf("empty condition")
+		}
+		return nil, c.errorf("expected ~ at end of condition")
+	}
+	if c.text[c.pos] != '~' {
+		return nil, c.errorf("expected ~, found %q", c.found())
+	}
+	term := &Term{Offset: c.pos}
+	c.pos++
+	
+	if c.pos < len(c.text) && c.text[c.pos] == '(' {
+		open := c.pos
+		c.pos++
+		sub, err := c.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		c.skipSpace()
+		if c.pos >= len(c.text) {
+			return nil, &SyntaxError{Offset: open, Msg: "unclosed ("}
+		}
+		if c.text[c.pos] != ')' {
+			return nil, c.errorf("expected | or ), found %q", c.found())
+		}
+		c.pos++
+		term.Sub = sub
+		return term, nil
+	}
+	
+	start := c.pos
+	for c.pos < len(c.text) {
+		r, size := utf8.DecodeRuneInString(c.text[c.pos:])
+		if strings.ContainsRune("~|()", r) || unicode.IsSpace(r) {
+			break
+		}
+		c.pos += size
+	}
+	if c.pos == start {
+		if c.pos >= len(c.text) {
+			return nil, c.errorf("expected variable or ( after ~")
+		}
+		return nil, c.errorf("expected variable or ( after ~, found %q", c.found())
+	}
+	term.Name = c.text[start:c.pos]
+	return term, nil
+}
+
+func (c *conditionParser) skipSpace() {
+	for c.pos < len(c.text) {
+		r, size := utf8.DecodeRuneInString(c.text[c.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		c.pos += size
+	}
+}
+
+// found returns the rune at the current position for error messages
+func (c *conditionParser) found() string {
+	r, _ := utf8.DecodeRuneInString(c.text[c.pos:])
+	return string(r)
+}
+
+func (c *conditionParser) errorf(format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Offset: c.pos, Msg: fmt.Sprintf(format, args...)}
+}
\ No newline at end of file
@@ -0,0 +1,154 @@
+package opp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	cond, err := ParseCondition("~a | ~(~b|~c)")
+	if err != nil {
+		t.Fatalf("ParseCondition() error = %v", err)
+	}
+
+	expected := &Condition{Terms: []*Term{
+		{Offset: 0, Name: "a"},
+		{Offset: 5, Sub: &Condition{Terms: []*Term{
+			{Offset: 7, Name: "b"},
+			{Offset: 10, Name: "c"},
+		}}},
+	}}
+	if !reflect.DeepEqual(cond, expected) {
+		t.Errorf("ParseCondition() = %#v, want %#v", cond, expected)
+	}
+	if got := cond.String(); got != "~a|~(~b|~c)" {
+		t.Errorf("String() = %q, want %q", got, "~a|~(~b|~c)")
+	}
+	if got := cond.Variables(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Variables() = %v, want [a b c]", got)
+	}
+}
+
+func TestConditionEval(t *testing.T) {
+	tests := []struct {
+		condition string
+		// expected holds the result for each assignment of a, b and c,
+		// where bit 0 of the index is a
+		expected [8]bool
+	}{
+		{"~a", [8]bool{false, true, false, true, false, true, false, true}},
+		{"~a|~a", [8]bool{true, false, true, false, true, false, true, false}},
+		{"~a|~b", [8]bool{true, true, true, false, true, true, true, false}},
+		{"~a|~b|~c", [8]bool{true, true, true, true, true, true, true, false}},
+		{"~(~a|~a)|~(~a|~a)", [8]bool{false, true, false, true, false, true, false, true}},
+		{"~(~a|~b)|~(~a|~b)", [8]bool{false, false, false, true, false, false, false, true}},
+		{"~(~a|~b|~c)", [8]bool{true, true, true, true, true, true, true, false}},
+		{"~(~(~a|~b|~c)|~(~a|~b|~c))", [8]bool{false, false, false, false, false, false, false, true}},
+	}
+
+	for _, tt := range tests {
+		cond, err := ParseCondition(tt.condition)
+		if err != nil {
+			t.Fatalf("ParseCondition(%q) error = %v", tt.condition, err)
+		}
+		for i, want := range tt.expected {
+			values := map[string]bool{"a": i&1 != 0, "b": i&2 != 0, "c": i&4 != 0}
+			if got := cond.Eval(func(name string) bool { return values[name] }); got != want {
+				t.Errorf("%s with %v = %v, want %v", tt.condition, values, got, want)
+			}
+		}
+	}
+}
+
+func TestConditionSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		condition string
+		offset    int
+		msg       string
+	}{
+		{"", 0, "empty condition"},
+		{"a|~b", 0, `expected ~, found "a"`},
+		{"~a|", 3, "expected ~ at end of condition"},
+		{"~a|b", 3, `expected ~, found "b"`},
+		{"~", 1, "expected variable or ( after ~"},
+		{"~ a", 1, `expected variable or ( after ~, found " "`},
+		{"~(a", 2, `expected ~, found "a"`},
+		{"~(~a", 1, "unclosed ("},
+		{"~(~a~b)", 4, `expected | or ), found "~"`},
+		{"~a)", 2, "unexpected )"},
+		{"~a ~b", 3, `expected | or end of condition, found "~"`},
+		{"~()", 2, `expected ~, found ")"`},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseCondition(tt.condition)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("ParseCondition(%q) error = %v, want syntax error", tt.condition, err)
+			continue
+		}
+		if serr.Offset != tt.offset || serr.Msg != tt.msg {
+			t.Errorf("ParseCondition(%q) error at %d: %s, want at %d: %s", tt.condition, serr.Offset, serr.Msg, tt.offset, tt.msg)
+		}
+	}
+}
+
+func TestConditionDirectives(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "chained terms",
+			input:    "##~A|~B|~C\nsome undefined\n##.",
+			expected: "some undefined",
+		},
+		{
+			name:     "chained terms all defined",
+			input:    "##~X|~X|~X\nsome undefined\n##.",
+			expected: "",
+		},
+		{
+			// A single term has always meant its object
+			name:     "lone term",
+			input:    "##~X\nX defined\n##.\n##~A\nA defined\n##.\n##~(~X|~A)\nsome undefined\n##.",
+			expected: "X defined\nsome undefined",
+		},
+		{
+			name:     "else with chained terms",
+			input:    "##~X|~X\nno X\n##@~A|~B|~C\nsome undefined\n##.",
+			expected: "some undefined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.Define("X", "")
+			result, err := p.Process(tt.input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConditionErrorPosition(t *testing.T) {
+	_, err := New().Process("##~a|~a\n##@~(~a|b)\n##.")
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("Process() error = %v, want *Error", err)
+	}
+	if perr.Pos.Line != 2 || perr.Pos.Column != 9 || perr.Directive != DirectiveElse {
+		t.Errorf("error = %v (%s), want 2:9 in else directive", perr, perr.Directive)
+	}
+	var serr *SyntaxError
+	if !errors.As(err, &serr) {
+		t.Errorf("error = %v, want a SyntaxError inside", err)
+	}
+}
\ No newline at end of file
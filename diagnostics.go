@@ -0,0 +1,100 @@
+package opp
+
+import (
+	"strings"
+)
+
+// Severity classifies a Diagnostic
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic is a single problem recorded while processing. The embedded
+// Error carries the position, directive kind and include chain.
+type Diagnostic struct {
+	Severity Severity
+	// Warning is the warning class, or zero for plain errors
+	Warning Warning
+	*Error
+}
+
+// String formats the diagnostic as "position: severity: message"
+func (d *Diagnostic) String() string {
+	msg := d.Pos.String() + ": " + d.Severity.String() + ": " + d.Err.Error()
+	if d.Warning != 0 {
+		msg += " [" + d.Warning.String() + "]"
+	}
+	for i := len(d.IncludeChain) - 1; i >= 0; i-- {
+		msg += " (included from " + d.IncludeChain[i].String() + ")"
+	}
+	return msg
+}
+
+// Diagnostics is a list of diagnostics in the order they were recorded. In
+// keep-going mode Process returns it as its error when any of them is an
+// error; errors.As can be used to reach the individual *Error values.
+type Diagnostics []*Diagnostic
+
+func (d Diagnostics) Error() string {
+	lines := make([]string, 0, len(d))
+	for _, diag := range d {
+		lines = append(lines, diag.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap exposes the error-severity diagnostics to errors.Is and errors.As
+func (d Diagnostics) Unwrap() []error {
+	var errs []error
+	for _, diag := range d {
+		if diag.Severity == SeverityError {
+			errs = append(errs, diag.Error)
+		}
+	}
+	return errs
+}
+
+// HasErrors reports whether any diagnostic has error severity
+func (d Diagnostics) HasErrors() bool {
+	for _, diag := range d {
+		if diag.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// tolerate records err as a diagnostic when running in keep-going mode. It
+// reports whether the caller should carry on instead of returning err.
+func (p *Preprocessor) tolerate(err error) bool {
+	if !p.keepGoing || isFatal(err) {
+		return false
+	}
+	p.diagnostics = append(p.diagnostics, &Diagnostic{
+		Severity: SeverityError,
+		Error:    p.wrapError(DirectiveNone, -1, err),
+	})
+	return true
+}
+
+// tolerateOrFail returns nil if err was recorded by tolerate, and err otherwise
+func (p *Preprocessor) tolerateOrFail(err error) error {
+	if err == nil || p.tolerate(err) {
+		return nil
+	}
+	return err
+}
\ No newline at end of file
@@ -0,0 +1,95 @@
+package opp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeepGoingCollectsAllErrors(t *testing.T) {
+	input := `first
+##~A|B
+skipped because the condition is false
+##.
+##~C|D|
+also skipped
+##.
+##?
+##<missing\.h.
+last`
+
+	p := New()
+	p.SetKeepGoing(true)
+	result, err := p.Process(input)
+
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) {
+		t.Fatalf("Process() error = %v, want Diagnostics", err)
+	}
+
+	wantLines := []int{2, 5, 8, 9}
+	if len(diagnostics) != len(wantLines) {
+		t.Fatalf("got %d diagnostics, want %d:\n%v", len(diagnostics), len(wantLines), diagnostics)
+	}
+	for i, d := range diagnostics {
+		if d.Severity != SeverityError {
+			t.Errorf("diagnostic %d severity = %v, want error", i, d.Severity)
+		}
+		if d.Pos.Line != wantLines[i] {
+			t.Errorf("diagnostic %d line = %d, want %d", i, d.Pos.Line, wantLines[i])
+		}
+	}
+
+	if expected := "first\nlast"; result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Pos.Line != 2 {
+		t.Errorf("errors.As(*Error) = %v, want the first diagnostic", perr)
+	}
+}
+
+func TestKeepGoingUnbalancedBlocks(t *testing.T) {
+	p := New()
+	p.SetKeepGoing(true)
+	_, err := p.Process("##.\n##@\n##~A|~A\ntext")
+
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) {
+		t.Fatalf("Process() error = %v, want Diagnostics", err)
+	}
+	if len(diagnostics) != 3 {
+		t.Fatalf("got %d diagnostics, want 3:\n%v", len(diagnostics), diagnostics)
+	}
+	if diagnostics[0].Directive != DirectiveEnd || diagnostics[1].Directive != DirectiveElse {
+		t.Errorf("unexpected directives: %v, %v", diagnostics[0].Directive, diagnostics[1].Directive)
+	}
+}
+
+func TestKeepGoingWithoutErrors(t *testing.T) {
+	p := New()
+	p.SetKeepGoing(true)
+	result, err := p.Process("##:A b\nA")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "b" {
+		t.Errorf("Process() = %q, want %q", result, "b")
+	}
+	if len(p.Diagnostics()) != 0 {
+		t.Errorf("Diagnostics() = %v, want none", p.Diagnostics())
+	}
+}
+
+func TestStopsAtFirstErrorByDefault(t *testing.T) {
+	_, err := New().Process("##~A|B\n##.\n##?")
+
+	var diagnostics Diagnostics
+	if errors.As(err, &diagnostics) {
+		t.Fatalf("Process() returned Diagnostics without keep-going mode")
+	}
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Pos.Line != 1 {
+		t.Errorf("Process() error = %v, want *Error on line 1", err)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,38 @@
+package opp
+
+import (
+	"testing"
+)
+
+func TestEnvironment(t *testing.T) {
+	env := map[string]string{"OPP_DEBUG": "", "WINDOWS": "1"}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	input := "##~(~DEBUG)\ndebug\n##.\n##~(~WINDOWS)\nwindows\n##.\nDEBUG"
+
+	tests := []struct {
+		name     string
+		prefix   string
+		lookup   func(string) (string, bool)
+		expected string
+	}{
+		{"disabled", "", nil, "DEBUG"},
+		{"whole environment", "", lookup, "windows\nDEBUG"},
+		{"prefix", "OPP_", lookup, "debug\nDEBUG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.SetEnvironment(tt.prefix, tt.lookup)
+			result, err := p.Process(input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,127 @@
+package opp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// DirectiveKind identifies the OPP directive a diagnostic refers to
+type DirectiveKind int
+
+const (
+	DirectiveNone       DirectiveKind = iota // plain source text
+	DirectiveCondition                       // ##~
+	DirectiveElse                            // ##@
+	DirectiveEnd                             // ##.
+	DirectiveInclude                         // ##<
+	DirectiveDefine                          // ##:
+	DirectiveUndefine                        // ##-
+	DirectivePredefined                      // ##i, ##_, ##$, ##{, ##}
+	DirectiveUnknown                         // any other ## line
+)
+
+var directiveNames = [...]string{
+	DirectiveNone:       "text",
+	DirectiveCondition:  "##~",
+	DirectiveElse:       "##@",
+	DirectiveEnd:        "##.",
+	DirectiveInclude:    "##<",
+	DirectiveDefine:     "##:",
+	DirectiveUndefine:   "##-",
+	DirectivePredefined: "predefined macro",
+	DirectiveUnknown:    "unknown directive",
+}
+
+func (k DirectiveKind) String() string {
+	if k >= 0 && int(k) < len(directiveNames) {
+		return directiveNames[k]
+	}
+	return "DirectiveKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Position is a location in an OPP source file. Line and Column are 1-based;
+// Column counts bytes. A zero Line or Column means the value is unknown.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// String formats the position like go/token: file:line:column, omitting
+// the parts that are unknown
+func (pos Position) String() string {
+	s := pos.File
+	if pos.Line > 0 {
+		if s != "" {
+			s += ":"
+		}
+		s += strconv.Itoa(pos.Line)
+		if pos.Column > 0 {
+			s += ":" + strconv.Itoa(pos.Column)
+		}
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Error describes a preprocessing failure at a specific source position.
+// Every error returned by Process and ProcessFile can be inspected with
+// errors.As(err, **Error).
+type Error struct {
+	Pos       Position
+	Directive DirectiveKind
+	// IncludeChain lists the ##< directives that led to Pos, outermost first
+	IncludeChain []Position
+	Err          error
+}
+
+func (e *Error) Error() string {
+	msg := e.Pos.String() + ": " + e.Err.Error()
+	for i := len(e.IncludeChain) - 1; i >= 0; i-- {
+		msg += " (included from " + e.IncludeChain[i].String() + ")"
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorf creates an Error at the given 0-based byte offset of the current line
+func (p *Preprocessor) errorf(kind DirectiveKind, offset int, format string, args ...interface{}) *Error {
+	return p.wrapError(kind, offset, fmt.Errorf(format, args...))
+}
+
+// wrapError attaches the current position to err unless it already carries one
+func (p *Preprocessor) wrapError(kind DirectiveKind, offset int, err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{
+		Pos:          p.position(offset),
+		Directive:    kind,
+		IncludeChain: p.includeChain(),
+		Err:          err,
+	}
+}
+
+// includeChain returns a copy of the current include stack, or nil at the
+// top level
+func (p *Preprocessor) includeChain() []Position {
+	if len(p.includeStack) == 0 {
+		return nil
+	}
+	return append([]Position(nil), p.includeStack...)
+}
+
+// position returns the location of a 0-based byte offset in the current
+// line; a negative offset leaves the column unknown
+func (p *Preprocessor) position(offset int) Position {
+	column := offset + 1
+	if column < 0 {
+		column = 0
+	}
+	return Position{File: p.currentFile, Line: p.lineNumber, Column: column}
+}
\ No newline at end of file
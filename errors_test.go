@@ -0,0 +1,207 @@
+package opp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		line      int
+		column    int
+		directive DirectiveKind
+	}{
+		{
+			name:      "bad right term",
+			input:     "##~A|B",
+			line:      1,
+			column:    6,
+			directive: DirectiveCondition,
+		},
+		{
+			name:      "bad term in indented condition",
+			input:     "text\n    ##~(~A|B)|~A\n##.",
+			line:      2,
+			column:    12,
+			directive: DirectiveCondition,
+		},
+		{
+			name:      "bad term after else",
+			input:     "##~A|~A\n##@A|~A\n##.",
+			line:      2,
+			column:    4,
+			directive: DirectiveElse,
+		},
+		{
+			name:      "missing include file",
+			input:     "\n  ##<missing\\.h.",
+			line:      2,
+			column:    6,
+			directive: DirectiveInclude,
+		},
+		{
+			name:      "invalid include syntax",
+			input:     "##<file.h",
+			line:      1,
+			column:    1,
+			directive: DirectiveInclude,
+		},
+		{
+			name:      "missing macro name",
+			input:     "##: body",
+			line:      1,
+			column:    4,
+			directive: DirectiveDefine,
+		},
+		{
+			name:      "unmatched end",
+			input:     "a\n##.",
+			line:      2,
+			column:    1,
+			directive: DirectiveEnd,
+		},
+		{
+			name:      "unknown directive",
+			input:     "##?",
+			line:      1,
+			column:    1,
+			directive: DirectiveUnknown,
+		},
+		{
+			name:      "unclosed block",
+			input:     "##~A|~A\na",
+			line:      2,
+			column:    0,
+			directive: DirectiveNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().Process(tt.input)
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("Process() error = %v, want *Error", err)
+			}
+			if perr.Pos.Line != tt.line || perr.Pos.Column != tt.column {
+				t.Errorf("position = %d:%d, want %d:%d", perr.Pos.Line, perr.Pos.Column, tt.line, tt.column)
+			}
+			if perr.Directive != tt.directive {
+				t.Errorf("directive = %v, want %v", perr.Directive, tt.directive)
+			}
+		})
+	}
+}
+
+func TestErrorIncludeChain(t *testing.T) {
+	tempDir := t.TempDir()
+
+	files := map[string]string{
+		"inner.h":  "ok\n##~A|B\n##.",
+		"middle.h": "middle\n\n##<inner\\.h.",
+		"main.c":   "##<middle\\.h.",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	mainFile := filepath.Join(tempDir, "main.c")
+	_, err := New().ProcessFile(mainFile)
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("ProcessFile() error = %v, want *Error", err)
+	}
+
+	want := Position{File: filepath.Join(tempDir, "inner.h"), Line: 2, Column: 6}
+	if perr.Pos != want {
+		t.Errorf("Pos = %v, want %v", perr.Pos, want)
+	}
+
+	chain := []Position{
+		{File: mainFile, Line: 1, Column: 1},
+		{File: filepath.Join(tempDir, "middle.h"), Line: 3, Column: 1},
+	}
+	if len(perr.IncludeChain) != len(chain) {
+		t.Fatalf("IncludeChain = %v, want %v", perr.IncludeChain, chain)
+	}
+	for i := range chain {
+		if perr.IncludeChain[i] != chain[i] {
+			t.Errorf("IncludeChain[%d] = %v, want %v", i, perr.IncludeChain[i], chain[i])
+		}
+	}
+}
+
+func TestUnclosedBlocks(t *testing.T) {
+	_, err := New().Process("##~A\na\n##@~B\n  ##~C\n##.\n  ##~D\n##@\nb")
+	var unclosed *UnclosedError
+	if !errors.As(err, &unclosed) {
+		t.Fatalf("Process() error = %v, want *UnclosedError", err)
+	}
+	want := "unclosed conditional blocks: ##~ at 1:1, ##@ at 3:1; ##~ at 6:3, ##@ at 7:1"
+	if unclosed.Error() != want {
+		t.Errorf("Error() = %q, want %q", unclosed.Error(), want)
+	}
+	if len(unclosed.Blocks) != 2 || len(unclosed.Blocks[1]) != 2 || unclosed.Blocks[1][0] != (Position{Line: 6, Column: 3}) {
+		t.Errorf("Blocks = %v", unclosed.Blocks)
+	}
+}
+
+func TestUnclosedBlockInInclude(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"header.h": "##~A|~A\nheader",
+		"main.c":   "##<header\\.h.\n##.\nmain",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	// The include can't leave its block open for main.c to close
+	p := New()
+	p.SetKeepGoing(true)
+	result, err := p.ProcessFile(filepath.Join(tempDir, "main.c"))
+	if result != "header\nmain" {
+		t.Errorf("ProcessFile() = %q, want %q", result, "header\nmain")
+	}
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 2 {
+		t.Fatalf("ProcessFile() error = %v, want two diagnostics", err)
+	}
+	var unclosed *UnclosedError
+	if !errors.As(diagnostics[0].Error, &unclosed) || unclosed.Blocks[0][0].File != filepath.Join(tempDir, "header.h") {
+		t.Errorf("diagnostics[0] = %v, want the block left open in header.h", diagnostics[0])
+	}
+	if len(diagnostics[0].IncludeChain) != 1 {
+		t.Errorf("IncludeChain = %v, want the ##< in main.c", diagnostics[0].IncludeChain)
+	}
+	if diagnostics[1].Directive != DirectiveEnd || diagnostics[1].Pos.Line != 2 {
+		t.Errorf("diagnostics[1] = %v, want the ##. in main.c", diagnostics[1])
+	}
+}
+
+func TestPositionString(t *testing.T) {
+	tests := []struct {
+		pos      Position
+		expected string
+	}{
+		{Position{File: "a.opp", Line: 3, Column: 7}, "a.opp:3:7"},
+		{Position{File: "a.opp", Line: 3}, "a.opp:3"},
+		{Position{File: "a.opp"}, "a.opp"},
+		{Position{Line: 2, Column: 1}, "2:1"},
+		{Position{}, "-"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.pos.String(); got != tt.expected {
+			t.Errorf("Position.String() = %q, want %q", got, tt.expected)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,160 @@
+package opp
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Explanation describes a ##~ or ##@ directive in readable form
+type Explanation struct {
+	// Pos is the position of the ##
+	Pos Position
+	// Directive is DirectiveCondition for ##~ and DirectiveElse for ##@
+	Directive DirectiveKind
+	// Condition is the parsed condition, nil for a bare ##@
+	Condition *Condition
+	// Expr is the simplified condition, nil for a bare ##@
+	Expr Expr
+}
+
+// String returns the explanation as "if X && Y", "else if X" or "else"
+func (e *Explanation) String() string {
+	switch {
+	case e.Directive == DirectiveCondition:
+		return "if " + e.Expr.String()
+	case e.Expr != nil:
+		return "else if " + e.Expr.String()
+	default:
+		return "else"
+	}
+}
+
+// Explain parses every condition in r, which is read from filename, and
+// returns their readable forms. Conditions with syntax errors are left out
+// and reported together as Diagnostics.
+func Explain(r io.Reader, filename string) ([]*Explanation, error) {
+	var explanations []*Explanation
+	err := scanConditions(r, filename, func(line string, d *conditionDirective) error {
+		if d.explained() {
+			explanations = append(explanations, &d.Explanation)
+		}
+		return nil
+	})
+	return explanations, err
+}
+
+// ExplainFile works like Explain for the named file
+func ExplainFile(filename string) ([]*Explanation, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, &Error{Pos: Position{File: filename}, Err: err}
+	}
+	defer f.Close()
+	return Explain(f, filename)
+}
+
+// Annotate copies r to w and inserts a line comment with the explanation
+// after every condition, indented like the directive. comment is the line
+// comment marker of the target language, such as // or #. The comments are
+// inside the blocks they describe, so the output of the annotated file keeps
+// those of the blocks taken. It is not otherwise the same: the inserted
+// lines shift ##_, line directives and source maps, and in plain text,
+// where comments are not protected, macros in the comments are expanded.
+func Annotate(r io.Reader, w io.Writer, filename, comment string) error {
+	bw := bufio.NewWriter(w)
+	first := true
+	err := scanConditions(r, filename, func(line string, d *conditionDirective) error {
+		if !first {
+			bw.WriteByte('\n')
+		}
+		first = false
+		bw.WriteString(line)
+		if d.explained() {
+			indent := line[:len(line)-len(strings.TrimLeftFunc(line, unicode.IsSpace))]
+			bw.WriteString("\n" + indent + comment + " " + d.String())
+		}
+		return nil
+	})
+	if _, ok := err.(Diagnostics); err != nil && !ok {
+		return err
+	}
+	if ferr := bw.Flush(); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// conditionDirective is a ##~, ##@ or ##. line found by scanConditions
+type conditionDirective struct {
+	Explanation
+	// invalid is set if the condition has a syntax error
+	invalid bool
+}
+
+// explained reports whether d is a condition with an explanation
+func (d *conditionDirective) explained() bool {
+	return d != nil && d.Directive != DirectiveEnd && !d.invalid
+}
+
+// scanConditions calls fn for every line of r, with the conditional
+// directive on that line, if any. Syntax errors are collected and returned
+// as Diagnostics at the end.
+func scanConditions(r io.Reader, filename string, fn func(line string, d *conditionDirective) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	scanner.Split(scanLines)
+	
+	var diagnostics Diagnostics
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		offset := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+		pos := Position{File: filename, Line: lineNumber, Column: offset + 1}
+		
+		var d *conditionDirective
+		switch {
+		case trimmed == "##.":
+			d = &conditionDirective{Explanation: Explanation{Pos: pos, Directive: DirectiveEnd}}
+			trimmed = ""
+		case strings.HasPrefix(trimmed, "##~"):
+			d = &conditionDirective{Explanation: Explanation{Pos: pos, Directive: DirectiveCondition}}
+			trimmed = trimmed[2:]
+			offset += 2
+		case strings.HasPrefix(trimmed, "##@"):
+			d = &conditionDirective{Explanation: Explanation{Pos: pos, Directive: DirectiveElse}}
+			trimmed = trimmed[3:]
+			offset += 3
+		}
+		
+		if d != nil && trimmed != "" {
+			cond, err := ParseCondition(trimmed)
+			if err != nil {
+				errPos := pos
+				errPos.Column = offset + err.(*SyntaxError).Offset + 1
+				diagnostics = append(diagnostics, &Diagnostic{
+					Severity: SeverityError,
+					Error:    &Error{Pos: errPos, Directive: d.Directive, Err: err},
+				})
+				d.invalid = true
+			} else {
+				d.Condition = cond
+				d.Expr = Simplify(cond.Expr())
+			}
+		}
+		
+		if err := fn(line, d); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return &Error{Pos: Position{File: filename}, Err: err}
+	}
+	
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+	return nil
+}
\ No newline at end of file
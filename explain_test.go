@@ -0,0 +1,86 @@
+package opp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	input := strings.Join([]string{
+		"##~(~X|~X)|~(~Y|~Y)",
+		"x or y",
+		"  ##@~DEBUG|~DEBUG",
+		"no debug",
+		"##@",
+		"##.",
+		"##~A|~B|~C",
+		"##.",
+	}, "\n")
+
+	explanations, err := Explain(strings.NewReader(input), "main.opp")
+	if err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+
+	expected := []string{
+		"main.opp:1:1: if X || Y",
+		"main.opp:3:3: else if !DEBUG",
+		"main.opp:5:1: else",
+		"main.opp:7:1: if !A || !B || !C",
+	}
+	if len(explanations) != len(expected) {
+		t.Fatalf("Explain() returned %d explanations, want %d", len(explanations), len(expected))
+	}
+	for i, e := range explanations {
+		if got := e.Pos.String() + ": " + e.String(); got != expected[i] {
+			t.Errorf("explanation %d = %q, want %q", i, got, expected[i])
+		}
+	}
+	if explanations[0].Condition.String() != "~(~X|~X)|~(~Y|~Y)" {
+		t.Errorf("Condition = %s", explanations[0].Condition)
+	}
+}
+
+func TestExplainSyntaxErrors(t *testing.T) {
+	explanations, err := Explain(strings.NewReader("##~(~a\n##~a|~a\n##@~a|b"), "main.opp")
+	if len(explanations) != 1 || explanations[0].String() != "if !a" {
+		t.Errorf("Explain() = %v, want only the valid condition", explanations)
+	}
+
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 2 {
+		t.Fatalf("Explain() error = %v, want 2 diagnostics", err)
+	}
+	if got := diagnostics[0].Pos.String(); got != "main.opp:1:4" {
+		t.Errorf("first error at %s, want main.opp:1:4", got)
+	}
+	if got := diagnostics[1].Pos.String(); got != "main.opp:3:7" {
+		t.Errorf("second error at %s, want main.opp:3:7", got)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	input := "int x;\n  ##~(~DEBUG|~DEBUG)|~(~DEBUG|~DEBUG)\n  log();\n  ##@\n##.\n"
+
+	var out strings.Builder
+	if err := Annotate(strings.NewReader(input), &out, "main.c", "//"); err != nil {
+		t.Fatalf("Annotate() error = %v", err)
+	}
+
+	expected := "int x;\n  ##~(~DEBUG|~DEBUG)|~(~DEBUG|~DEBUG)\n  // if DEBUG\n  log();\n  ##@\n  // else\n##.\n"
+	if out.String() != expected {
+		t.Errorf("Annotate() = %q, want %q", out.String(), expected)
+	}
+
+	// The annotated file keeps the comments of the blocks taken
+	p := New()
+	p.Define("DEBUG", "")
+	result, err := p.Process(out.String())
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "int x;\n  // if DEBUG\n  log();" {
+		t.Errorf("Process() = %q", result)
+	}
+}
\ No newline at end of file
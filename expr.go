@@ -0,0 +1,376 @@
+package opp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Expr is a boolean expression over defined variables, in the notation
+// people write conditions in: A && !B || defined(C)
+type Expr interface {
+	// Eval evaluates the expression; defined reports whether a variable is
+	// defined
+	Eval(defined func(name string) bool) bool
+	// String formats the expression with the fewest parentheses needed
+	String() string
+}
+
+// Var is true if the variable Name is defined
+type Var struct {
+	Name string
+}
+
+// Not negates X
+type Not struct {
+	X Expr
+}
+
+// And is true if all of its operands are
+type And []Expr
+
+// Or is true if any of its operands is
+type Or []Expr
+
+func (v Var) Eval(defined func(string) bool) bool { return defined(v.Name) }
+func (n Not) Eval(defined func(string) bool) bool { return !n.X.Eval(defined) }
+
+func (a And) Eval(defined func(string) bool) bool {
+	for _, x := range a {
+		if !x.Eval(defined) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o Or) Eval(defined func(string) bool) bool {
+	for _, x := range o {
+		if x.Eval(defined) {
+			return true
+		}
+	}
+	return false
+}
+
+// Operator precedence for String
+const (
+	precOr = iota
+	precAnd
+	precNot
+)
+
+func (v Var) String() string { return v.Name }
+func (n Not) String() string { return "!" + format(n.X, precNot) }
+func (a And) String() string { return join(a, " && ", precAnd) }
+func (o Or) String() string  { return join(o, " || ", precOr) }
+
+func join(operands []Expr, op string, prec int) string {
+	parts := make([]string, len(operands))
+	for i, x := range operands {
+		parts[i] = format(x, prec+1)
+	}
+	return strings.Join(parts, op)
+}
+
+// format formats x, parenthesized if it binds weaker than prec
+func format(x Expr, prec int) string {
+	p := precNot
+	switch x.(type) {
+	case Or:
+		p = precOr
+	case And:
+		p = precAnd
+	}
+	if p < prec {
+		return "(" + x.String() + ")"
+	}
+	return x.String()
+}
+
+// ParseExpr parses a boolean expression. Operators are !, && and ||, with
+// the usual precedence, and parentheses. defined(NAME) is the same as NAME.
+func ParseExpr(text string) (Expr, error) {
+	e := &exprParser{text: text}
+	x, err := e.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	e.skipSpace()
+	if e.pos < len(e.text) {
+		return nil, e.errorf("unexpected %q", e.found())
+	}
+	return x, nil
+}
+
+type exprParser struct {
+	text string
+	pos  int
+}
+
+func (e *exprParser) parseOr() (Expr, error) {
+	x, err := e.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	operands := Or{x}
+	for e.accept("||") {
+		y, err := e.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, y)
+	}
+	if len(operands) == 1 {
+		return x, nil
+	}
+	return operands, nil
+}
+
+func (e *exprParser) parseAnd() (Expr, error) {
+	x, err := e.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	operands := And{x}
+	for e.accept("&&") {
+		y, err := e.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, y)
+	}
+	if len(operands) == 1 {
+		return x, nil
+	}
+	return operands, nil
+}
+
+func (e *exprParser) parseUnary() (Expr, error) {
+	if e.accept("!") {
+		x, err := e.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{x}, nil
+	}
+	
+	e.skipSpace()
+	if e.pos >= len(e.text) {
+		return nil, e.errorf("unexpected end of expression")
+	}
+	if e.accept("(") {
+		open := e.pos - 1
+		x, err := e.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !e.accept(")") {
+			if e.pos >= len(e.text) {
+				return nil, &SyntaxError{Offset: open, Msg: "unclosed ("}
+			}
+			return nil, e.errorf("expected ), found %q", e.found())
+		}
+		return x, nil
+	}
+	
+	start := e.pos
+	name := e.name()
+	if name == "" {
+		return nil, e.errorf("expected variable, found %q", e.found())
+	}
+	if name == "defined" && e.accept("(") {
+		inner := e.pos
+		if name = e.name(); name == "" {
+			return nil, &SyntaxError{Offset: inner, Msg: "expected variable in defined()"}
+		}
+		if !e.accept(")") {
+			return nil, &SyntaxError{Offset: start, Msg: "unclosed defined("}
+		}
+	}
+	return Var{name}, nil
+}
+
+// name scans a variable name: anything up to whitespace or an operator
+func (e *exprParser) name() string {
+	e.skipSpace()
+	start := e.pos
+	for e.pos < len(e.text) {
+		r, size := utf8.DecodeRuneInString(e.text[e.pos:])
+		if strings.ContainsRune("!&|()~", r) || unicode.IsSpace(r) {
+			break
+		}
+		e.pos += size
+	}
+	return e.text[start:e.pos]
+}
+
+// accept consumes token if it comes next
+func (e *exprParser) accept(token string) bool {
+	e.skipSpace()
+	if strings.HasPrefix(e.text[e.pos:], token) {
+		e.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (e *exprParser) skipSpace() {
+	for e.pos < len(e.text) {
+		r, size := utf8.DecodeRuneInString(e.text[e.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		e.pos += size
+	}
+}
+
+func (e *exprParser) found() string {
+	r, _ := utf8.DecodeRuneInString(e.text[e.pos:])
+	return string(r)
+}
+
+func (e *exprParser) errorf(format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Offset: e.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// CompileExpr translates x into an equivalent, short OPP condition. It is
+// minimized with Simplify first, so tautologies and contradictions become
+// the constant conditions.
+func CompileExpr(x Expr) *Condition {
+	x = simplify(Simplify(x))
+	if v, ok := x.(Var); ok {
+		// A single term means its object
+		return &Condition{Terms: []*Term{{Name: v.Name}}}
+	}
+	return &Condition{Terms: nandTerms(x)}
+}
+
+// CompileCondition translates a boolean expression like A && !B into the
+// equivalent OPP condition, here ~(~A|~(~B))
+func CompileCondition(text string) (*Condition, error) {
+	x, err := ParseExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	return CompileExpr(x), nil
+}
+
+// Expr returns the condition as a boolean expression, without simplifying
+// it; use Simplify for a readable form
+func (c *Condition) Expr() Expr {
+	objects := make(And, len(c.Terms))
+	for i, t := range c.Terms {
+		if t.Sub != nil {
+			objects[i] = t.Sub.Expr()
+		} else {
+			objects[i] = Var{t.Name}
+		}
+	}
+	if len(objects) == 1 {
+		return objects[0]
+	}
+	return Not{objects}
+}
+
+// nandTerms returns terms whose NAND is x
+func nandTerms(x Expr) []*Term {
+	var objects []Expr
+	switch x := x.(type) {
+	case Not:
+		// NAND(a, b) is !(a && b)
+		if operands, ok := x.X.(And); ok {
+			objects = operands
+		} else {
+			objects = []Expr{x.X}
+		}
+	case Or:
+		// a || b is NAND(!a, !b)
+		for _, operand := range x {
+			objects = append(objects, negate(operand))
+		}
+	case Const:
+		// Conditions have no constants, but v || !v is true for any v
+		v := Var{"_"}
+		if x {
+			objects = []Expr{v, Not{v}}
+		} else {
+			objects = []Expr{Const(true)}
+		}
+	default:
+		// x is NAND(!x)
+		objects = []Expr{negate(x)}
+	}
+	
+	// A single term would mean its object, so NAND(a) is written ~a|~a
+	if len(objects) == 1 {
+		objects = append(objects, objects[0])
+	}
+	
+	terms := make([]*Term, len(objects))
+	for i, object := range objects {
+		if v, ok := object.(Var); ok {
+			terms[i] = &Term{Name: v.Name}
+		} else {
+			terms[i] = &Term{Sub: &Condition{Terms: nandTerms(object)}}
+		}
+	}
+	return terms
+}
+
+// negate returns !x without double negation
+func negate(x Expr) Expr {
+	if n, ok := x.(Not); ok {
+		return n.X
+	}
+	return Not{x}
+}
+
+// simplify flattens nested operators of the same kind, removes double
+// negations and drops repeated operands
+func simplify(x Expr) Expr {
+	switch x := x.(type) {
+	case Not:
+		return negate(simplify(x.X))
+	case And:
+		operands := flatten(x, func(y Expr) ([]Expr, bool) { a, ok := y.(And); return a, ok })
+		if len(operands) == 1 {
+			return operands[0]
+		}
+		return And(operands)
+	case Or:
+		operands := flatten(x, func(y Expr) ([]Expr, bool) { o, ok := y.(Or); return o, ok })
+		if len(operands) == 1 {
+			return operands[0]
+		}
+		return Or(operands)
+	default:
+		return x
+	}
+}
+
+// flatten simplifies operands and splices in those of the same kind, as
+// reported by same, keeping only the first of equal operands
+func flatten(operands []Expr, same func(Expr) ([]Expr, bool)) []Expr {
+	var result []Expr
+	seen := map[string]bool{}
+	var add func(x Expr)
+	add = func(x Expr) {
+		x = simplify(x)
+		if inner, ok := same(x); ok {
+			for _, y := range inner {
+				add(y)
+			}
+			return
+		}
+		if key := x.String(); !seen[key] {
+			seen[key] = true
+			result = append(result, x)
+		}
+	}
+	for _, x := range operands {
+		add(x)
+	}
+	return result
+}
\ No newline at end of file
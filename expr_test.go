@@ -0,0 +1,143 @@
+package opp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompileCondition(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{"A", "~A"},
+		{"!A", "~A|~A"},
+		{"!!A", "~A"},
+		{"A && B", "~(~A|~B)|~(~A|~B)"},
+		{"!(A && B)", "~A|~B"},
+		{"A || B", "~(~A|~A)|~(~B|~B)"},
+		{"!A || !B || !C", "~A|~B|~C"},
+		{"A && !B || defined(C)", "~(~A|~(~B|~B))|~(~C|~C)"},
+		{"(A && B) && (C && A)", "~(~A|~B|~C)|~(~A|~B|~C)"},
+		{"!(A || B)", "~(~(~A|~A)|~(~B|~B))|~(~(~A|~A)|~(~B|~B))"},
+		{"defined (A) || A", "~A"},
+		{"A || !A", "~_|~(~_|~_)"},
+		{"A && !A", "~(~_|~(~_|~_))|~(~_|~(~_|~_))"},
+		{"(A && B) || (A && !B)", "~A"},
+		{"!(A || B) || A || B", "~_|~(~_|~_)"},
+	}
+
+	for _, tt := range tests {
+		cond, err := CompileCondition(tt.expr)
+		if err != nil {
+			t.Errorf("CompileCondition(%q) error = %v", tt.expr, err)
+			continue
+		}
+		if got := cond.String(); got != tt.expected {
+			t.Errorf("CompileCondition(%q) = %s, want %s", tt.expr, got, tt.expected)
+		}
+	}
+}
+
+// TestCompileConditionTruthTable checks that the preprocessor evaluates
+// every compiled condition exactly like the expression
+func TestCompileConditionTruthTable(t *testing.T) {
+	exprs := []string{
+		"A",
+		"!A",
+		"A && B",
+		"A || B",
+		"A && !B || defined(C)",
+		"!(A || B) && C",
+		"(A || !B) && (!A || C) && (B || C)",
+		"!(!(A && B) || !(B || !C))",
+		"A && A || !A && !A",
+		"(A && B) || (A && C) || (B && C)",
+		"A && (B || C && !(A || B))",
+		"A || !A",
+		"A && !B && (B || !A)",
+	}
+	names := []string{"A", "B", "C"}
+
+	for _, text := range exprs {
+		x, err := ParseExpr(text)
+		if err != nil {
+			t.Fatalf("ParseExpr(%q) error = %v", text, err)
+		}
+		cond := CompileExpr(x).String()
+
+		for bits := 0; bits < 1<<len(names); bits++ {
+			p := New()
+			defined := map[string]bool{}
+			for i, name := range names {
+				if bits&(1<<i) != 0 {
+					p.Define(name, "")
+					defined[name] = true
+				}
+			}
+
+			got, err := p.evaluateCondition(cond)
+			if err != nil {
+				t.Fatalf("evaluateCondition(%q) error = %v", cond, err)
+			}
+			if want := x.Eval(func(name string) bool { return defined[name] }); got != want {
+				t.Errorf("%s compiled to %s: %v with %v, want %v", text, cond, got, defined, want)
+			}
+		}
+	}
+}
+
+func TestExprString(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{"A&&B||C", "A && B || C"},
+		{"A&&(B||C)", "A && (B || C)"},
+		{"!(A&&B)", "!(A && B)"},
+		{"!!A", "!!A"},
+		{"((A))", "A"},
+		{"defined(A)", "A"},
+	}
+
+	for _, tt := range tests {
+		x, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) error = %v", tt.expr, err)
+			continue
+		}
+		if got := x.String(); got != tt.expected {
+			t.Errorf("ParseExpr(%q).String() = %q, want %q", tt.expr, got, tt.expected)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		offset int
+		msg    string
+	}{
+		{"", 0, "unexpected end of expression"},
+		{"A &&", 4, "unexpected end of expression"},
+		{"A && (B", 5, "unclosed ("},
+		{"A B", 2, `unexpected "B"`},
+		{"A & B", 2, `unexpected "&"`},
+		{"A || )", 5, `expected variable, found ")"`},
+		{"defined(A", 0, "unclosed defined("},
+		{"defined()", 8, "expected variable in defined()"},
+		{"(A B)", 3, `expected ), found "B"`},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseExpr(tt.expr)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("ParseExpr(%q) error = %v, want syntax error", tt.expr, err)
+			continue
+		}
+		if serr.Offset != tt.offset || serr.Msg != tt.msg {
+			t.Errorf("ParseExpr(%q) error at %d: %s, want at %d: %s", tt.expr, serr.Offset, serr.Msg, tt.offset, tt.msg)
+		}
+	}
+}
\ No newline at end of file
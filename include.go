@@ -1,16 +1,25 @@
 package opp
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func (p *Preprocessor) processInclude(directive string) (string, error) {
+// processInclude handles the text after ##; offset is the byte offset of the
+// ## within the current line. The included lines are emitted directly.
+func (p *Preprocessor) processInclude(directive string, offset int) (string, error) {
 	// Format: ##<<filename>.
 	if !strings.HasPrefix(directive, "<") || !strings.HasSuffix(directive, ".") {
-		return "", fmt.Errorf("invalid include syntax: ##%s", directive)
+		err := p.errorf(DirectiveInclude, offset, "invalid include syntax: ##%s", directive)
+		if p.tolerate(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	
+	if limit := p.limits.MaxIncludeDepth; limit > 0 && len(p.includeStack) >= limit {
+		return "", p.limitError(DirectiveInclude, offset, LimitIncludeDepth, int64(limit))
 	}
 	
 	// Extract filename
@@ -19,16 +28,21 @@ func (p *Preprocessor) processInclude(directive string) (string, error) {
 	// Unescape the bizarre OPP escape sequences
 	filename = unescapeFilename(filename)
 	
-	// Read the file
-	content, err := os.ReadFile(filename)
+	// Open the file
+	f, err := os.Open(filename)
 	if err != nil {
 		// Try relative to current file if we have that context
 		if p.currentFile != "" {
 			relPath := filepath.Join(filepath.Dir(p.currentFile), filename)
-			content, err = os.ReadFile(relPath)
+			f, err = os.Open(relPath)
 		}
 		if err != nil {
-			return "", fmt.Errorf("cannot read file %s: %w", filename, err)
+			perr := p.errorf(DirectiveInclude, offset+3, "cannot read file %s: %w", filename, err)
+			if p.tolerate(perr) {
+				// Skip the unreadable include
+				return "", nil
+			}
+			return "", perr
 		}
 	}
 	
@@ -38,29 +52,19 @@ func (p *Preprocessor) processInclude(directive string) (string, error) {
 		fullPath = filepath.Join(filepath.Dir(p.currentFile), filename)
 	}
 	
-	// Create a new preprocessor for the included file to avoid state pollution
-	// But share the macros and variables
-	includeProcessor := &Preprocessor{
-		macros:      p.macros,
-		variables:   p.variables,
-		random:      p.random,
-		lineNumber:  1,
-		braceCount:  p.braceCount,
-		closeBraces: p.closeBraces,
-		currentFile: fullPath,
-	}
+	// Process the included file with its own line numbers and conditional
+	// stack, sharing macros, variables and brace counts with the includer
+	savedFile, savedLine := p.currentFile, p.lineNumber
+	p.includeStack = append(p.includeStack, p.position(offset))
+	p.currentFile = fullPath
 	
-	// Process the included file
-	result, err := includeProcessor.Process(string(content))
-	if err != nil {
-		return "", fmt.Errorf("error processing included file %s: %w", filename, err)
-	}
+	err = p.processSource(f)
+	f.Close()
 	
-	// Update our brace counts from the included file
-	p.braceCount = includeProcessor.braceCount
-	p.closeBraces = includeProcessor.closeBraces
+	p.includeStack = p.includeStack[:len(p.includeStack)-1]
+	p.currentFile, p.lineNumber = savedFile, savedLine
 	
-	return result, nil
+	return "", err
 }
 
 // unescapeFilename reverses OPP's creative path escaping
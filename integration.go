@@ -1,26 +1,20 @@
 package opp
 
 import (
+	"context"
 	"io"
 )
 
 // ProcessReader processes input from an io.Reader
 func (p *Preprocessor) ProcessReader(r io.Reader) (string, error) {
-	input, err := io.ReadAll(r)
-	if err != nil {
-		return "", err
-	}
-	return p.Process(string(input))
+	return p.processToString(context.Background(), r, nil)
 }
 
-// ProcessWriter processes input and writes to an io.Writer
+// ProcessWriter processes input line by line and writes each output line to
+// w as soon as it is produced, so memory use does not grow with the size of
+// the input. Wrap w in a bufio.Writer when writing to a file.
 func (p *Preprocessor) ProcessWriter(r io.Reader, w io.Writer) error {
-	result, err := p.ProcessReader(r)
-	if err != nil {
-		return err
-	}
-	_, err = w.Write([]byte(result))
-	return err
+	return p.process(context.Background(), r, w, nil)
 }
 
 // DefaultPreprocessor returns a preprocessor with standard configuration
@@ -0,0 +1,92 @@
+package opp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signalWriter records output and signals when something was written
+type signalWriter struct {
+	buf     bytes.Buffer
+	written chan struct{}
+}
+
+func (w *signalWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	select {
+	case w.written <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func TestProcessWriterStreams(t *testing.T) {
+	pr, pw := io.Pipe()
+	w := &signalWriter{written: make(chan struct{}, 1)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- New().ProcessWriter(pr, w)
+	}()
+
+	// The first line must come out before the rest of the input exists
+	io.WriteString(pw, "##:A x\nA 1\n")
+	select {
+	case <-w.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no output before the input was complete")
+	}
+	if got := w.buf.String(); got != "x 1" {
+		t.Errorf("streamed output = %q, want %q", got, "x 1")
+	}
+
+	io.WriteString(pw, "A 2")
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("ProcessWriter() error = %v", err)
+	}
+	if got := w.buf.String(); got != "x 1\nx 2" {
+		t.Errorf("ProcessWriter() = %q, want %q", got, "x 1\nx 2")
+	}
+}
+
+func TestStreamingMatchesProcess(t *testing.T) {
+	inputs := []string{
+		"",
+		"a\n",
+		"a\r\nb\r\n",
+		"##~A|~A\n\nline\n##.\n\n",
+		"##:F(x) [#0]\nF(1) ##{ {\n}\n##}",
+	}
+
+	for _, input := range inputs {
+		expected, err := New().Process(input)
+		if err != nil {
+			t.Fatalf("Process(%q) error = %v", input, err)
+		}
+
+		result, err := New().ProcessReader(strings.NewReader(input))
+		if err != nil || result != expected {
+			t.Errorf("ProcessReader(%q) = %q, %v, want %q", input, result, err, expected)
+		}
+
+		var buf bytes.Buffer
+		if err := New().ProcessWriter(strings.NewReader(input), &buf); err != nil || buf.String() != expected {
+			t.Errorf("ProcessWriter(%q) = %q, %v, want %q", input, buf.String(), err, expected)
+		}
+	}
+}
+
+func TestProcessWriterLongLines(t *testing.T) {
+	line := strings.Repeat("x", 1<<20)
+	var buf bytes.Buffer
+	if err := New().ProcessWriter(strings.NewReader(line+"\n"+line), &buf); err != nil {
+		t.Fatalf("ProcessWriter() error = %v", err)
+	}
+	if buf.Len() != 2*len(line)+1 {
+		t.Errorf("output length = %d, want %d", buf.Len(), 2*len(line)+1)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,122 @@
+package opp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Language is a target-language profile. It decides how input is lexed and
+// how the predefined macros render.
+type Language struct {
+	// Name selects the profile in ParseLanguage and cmd/opp -lang
+	Name string
+	// Extensions lists the file extensions that select the profile
+	Extensions []string
+	// Lexer finds the literals and comments in which macros are not expanded
+	Lexer Lexer
+	// ImaginaryUnit is the expansion of ##i, or empty if the language has
+	// none. The numeric predefined macros render as decimal integers in
+	// every language.
+	ImaginaryUnit string
+	// LineComment starts a comment that runs to the end of the line, or is
+	// empty if the language has none
+	LineComment string
+}
+
+// The built-in language profiles
+var (
+	LanguageGo = &Language{
+		Name:          "go",
+		Extensions:    []string{".go"},
+		Lexer:         GoLexer,
+		ImaginaryUnit: "1i",
+		LineComment:   "//",
+	}
+	// LanguageC needs complex.h for ##i
+	LanguageC = &Language{
+		Name:          "c",
+		Extensions:    []string{".c", ".h"},
+		Lexer:         CLexer,
+		ImaginaryUnit: "_Complex_I",
+		LineComment:   "//",
+	}
+	// LanguageCPP needs <complex> for ##i
+	LanguageCPP = &Language{
+		Name:          "cpp",
+		Extensions:    []string{".cpp", ".cc", ".cxx", ".hpp", ".hh", ".hxx"},
+		Lexer:         CLexer,
+		ImaginaryUnit: "std::complex<double>(0, 1)",
+		LineComment:   "//",
+	}
+	LanguagePython = &Language{
+		Name:          "python",
+		Extensions:    []string{".py"},
+		Lexer:         PythonLexer,
+		ImaginaryUnit: "1j",
+		LineComment:   "#",
+	}
+	// LanguageJava has no ##i
+	LanguageJava = &Language{
+		Name:        "java",
+		Extensions:  []string{".java"},
+		Lexer:       CLexer,
+		LineComment: "//",
+	}
+	// LanguageJustif has no ##i
+	LanguageJustif = &Language{
+		Name:       "justif",
+		Extensions: []string{".justif"},
+		Lexer:      PlainLexer,
+	}
+	// LanguagePlain is used for unknown extensions and input without a file
+	// name. It expands everywhere and keeps the historical 1i for ##i.
+	LanguagePlain = &Language{
+		Name:          "plain",
+		Lexer:         PlainLexer,
+		ImaginaryUnit: "1i",
+	}
+)
+
+// Languages returns the built-in language profiles
+func Languages() []*Language {
+	return []*Language{LanguageGo, LanguageC, LanguageCPP, LanguagePython, LanguageJava, LanguageJustif, LanguagePlain}
+}
+
+// ParseLanguage returns the built-in profile with the given name
+func ParseLanguage(name string) (*Language, error) {
+	for _, l := range Languages() {
+		if l.Name == name {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown language %q", name)
+}
+
+// LanguageForFile picks the profile for filename from its extension, so
+// that main.opp.c is C. Unknown extensions get LanguagePlain.
+func LanguageForFile(filename string) *Language {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, l := range Languages() {
+		for _, e := range l.Extensions {
+			if e == ext {
+				return l
+			}
+		}
+	}
+	return LanguagePlain
+}
+
+// SetLanguage sets the language profile for all input, including included
+// files. By default it is chosen per file with LanguageForFile.
+func (p *Preprocessor) SetLanguage(l *Language) {
+	p.language = l
+}
+
+// currentLanguage returns the profile of the file being processed
+func (p *Preprocessor) currentLanguage() *Language {
+	if p.lexing.language == nil {
+		return LanguagePlain
+	}
+	return p.lexing.language
+}
\ No newline at end of file
@@ -0,0 +1,155 @@
+package opp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLanguageForFile(t *testing.T) {
+	tests := []struct {
+		file     string
+		expected *Language
+	}{
+		{"hello.opp.go", LanguageGo},
+		{"main.opp.c", LanguageC},
+		{"header.H", LanguageC},
+		{"main.opp.cpp", LanguageCPP},
+		{"script.opp.py", LanguagePython},
+		{"Main.opp.java", LanguageJava},
+		{"justif.opp.justif", LanguageJustif},
+		{"input.opp", LanguagePlain},
+		{"", LanguagePlain},
+	}
+
+	for _, tt := range tests {
+		if got := LanguageForFile(tt.file); got != tt.expected {
+			t.Errorf("LanguageForFile(%q) = %s, want %s", tt.file, got.Name, tt.expected.Name)
+		}
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	for _, l := range Languages() {
+		got, err := ParseLanguage(l.Name)
+		if err != nil || got != l {
+			t.Errorf("ParseLanguage(%q) = %v, %v", l.Name, got, err)
+		}
+	}
+	if _, err := ParseLanguage("cobol"); err == nil {
+		t.Error("ParseLanguage(\"cobol\") succeeded")
+	}
+}
+
+func TestImaginaryUnit(t *testing.T) {
+	tests := []struct {
+		file     string
+		expected string
+	}{
+		{"main.opp.go", "1i\nz := 2*1i\nx ##ix"},
+		{"main.opp.c", "_Complex_I\nz := 2*_Complex_I\nx ##ix"},
+		{"main.opp.cpp", "std::complex<double>(0, 1)\nz := 2*std::complex<double>(0, 1)\nx ##ix"},
+		{"main.opp.py", "1j\nz := 2*1j\nx ##ix"},
+		{"main.opp", "1i\nz := 2*1i\nx ##ix"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte("##i\nz := 2*##i\nx ##ix"), 0644); err != nil {
+				t.Fatalf("Failed to write input file: %v", err)
+			}
+
+			result, err := New().ProcessFile(path)
+			if err != nil {
+				t.Fatalf("ProcessFile() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("ProcessFile() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImaginaryUnitUnavailable(t *testing.T) {
+	p := New()
+	p.SetLanguage(LanguageJava)
+
+	// Like the other predefined macros, ##i is expanded inside strings and
+	// comments too
+	for _, input := range []string{"##i", "double z = ##i;", "String s = \"##i\";"} {
+		_, err := p.Process(input)
+		var perr *Error
+		if !errors.As(err, &perr) || perr.Directive != DirectivePredefined {
+			t.Errorf("Process(%q) error = %v, want predefined macro error", input, err)
+		}
+	}
+
+	result, err := p.Process("int ##ix;")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "int ##ix;" {
+		t.Errorf("Process() = %q, want %q", result, "int ##ix;")
+	}
+
+	// Keep-going mode leaves the ##i alone and carries on
+	p.SetKeepGoing(true)
+	result, err = p.Process("x ##i y\n##?\nafter")
+	if result != "x ##i y\nafter" {
+		t.Errorf("Process() = %q, want %q", result, "x ##i y\nafter")
+	}
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 2 || diagnostics[0].Directive != DirectivePredefined {
+		t.Errorf("Process() error = %v, want the ##i and the unknown directive", err)
+	}
+}
+
+func TestImaginaryUnitIsDefined(t *testing.T) {
+	p := New()
+	p.SetLanguage(LanguageGo)
+
+	// ##i counts as defined and can be undefined, after which it stays
+	result, err := p.Process("##~##i|~##i\nundefined\n##.\nz := ##i\n##-##i\nz := ##i")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "z := 1i\nz := ##i" {
+		t.Errorf("Process() = %q, want %q", result, "z := 1i\nz := ##i")
+	}
+	if warnings := p.Warnings(); len(warnings) != 0 {
+		t.Errorf("Warnings() = %v, want none", warnings)
+	}
+}
+
+func TestLanguagePerIncludedFile(t *testing.T) {
+	tempDir := t.TempDir()
+	header := filepath.Join(tempDir, "complex.h")
+	if err := os.WriteFile(header, []byte("##i"), 0644); err != nil {
+		t.Fatalf("Failed to write header file: %v", err)
+	}
+	mainFile := filepath.Join(tempDir, "main.opp.py")
+	if err := os.WriteFile(mainFile, []byte("##i\n##<complex\\.h.\n##i"), 0644); err != nil {
+		t.Fatalf("Failed to write main file: %v", err)
+	}
+
+	result, err := New().ProcessFile(mainFile)
+	if err != nil {
+		t.Fatalf("ProcessFile() error = %v", err)
+	}
+	if expected := "1j\n_Complex_I\n1j"; result != expected {
+		t.Errorf("ProcessFile() = %q, want %q", result, expected)
+	}
+
+	// An explicit language applies to included files too
+	p := New()
+	p.SetLanguage(LanguageGo)
+	result, err = p.ProcessFile(mainFile)
+	if err != nil {
+		t.Fatalf("ProcessFile() error = %v", err)
+	}
+	if expected := "1i\n1i\n1i"; result != expected {
+		t.Errorf("ProcessFile() = %q, want %q", result, expected)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,272 @@
+package opp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SpanKind classifies a Span
+type SpanKind int
+
+const (
+	SpanString SpanKind = iota
+	SpanChar
+	SpanComment
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanString:
+		return "string"
+	case SpanChar:
+		return "char"
+	case SpanComment:
+		return "comment"
+	default:
+		return fmt.Sprintf("SpanKind(%d)", int(k))
+	}
+}
+
+// Span is a string literal, character literal or comment in a line; Start
+// and End are byte offsets
+type Span struct {
+	Start, End int
+	Kind       SpanKind
+}
+
+// LexState is what a Lexer remembers between lines, such as being inside a
+// block comment. The zero value is the state at the start of a file.
+type LexState int
+
+// Lexer finds the spans of a target-language line in which macros are not
+// expanded
+type Lexer interface {
+	// Lex returns the spans of line in order. state is the state after the
+	// previous line and the returned state is passed to the next one.
+	Lex(line string, state LexState) ([]Span, LexState)
+}
+
+// The lexers for the supported target languages
+var (
+	// PlainLexer finds no spans at all
+	PlainLexer Lexer = plainLexer{}
+	// CLexer handles C, C++ and Java
+	CLexer Lexer = cLexer{}
+	// GoLexer handles Go, including multi-line raw strings
+	GoLexer Lexer = cLexer{rawStrings: true}
+	// PythonLexer handles Python, including multi-line triple-quoted strings
+	PythonLexer Lexer = pythonLexer{}
+)
+
+// SetExpandInLiterals makes macros expand inside strings, characters and
+// comments as well
+func (p *Preprocessor) SetExpandInLiterals(on bool) {
+	p.expandInLiterals = on
+}
+
+// lexing is the language of the file being processed and its lexer state
+type lexing struct {
+	language *Language
+	state    LexState
+}
+
+// startLexing selects the language of the current file and returns the
+// lexing state of the including file, to be restored when done
+func (p *Preprocessor) startLexing() lexing {
+	saved := p.lexing
+	language := p.language
+	if language == nil {
+		language = LanguageForFile(p.currentFile)
+	}
+	p.lexing = lexing{language: language}
+	return saved
+}
+
+// protectLiterals marks the spans of m.text[start:end] so they are not
+// expanded. The text is lexed on its own, starting outside any span.
+func (p *Preprocessor) protectLiterals(m *mappedLine, start, end int) {
+	if p.expandInLiterals || p.lexing.language == nil {
+		return
+	}
+	spans, _ := p.lexing.language.Lexer.Lex(string(m.text[start:end]), 0)
+	m.protect(spans, start)
+}
+
+// lexLine marks the spans of the source line m, carrying the lexer state
+// over from the previous line
+func (p *Preprocessor) lexLine(m *mappedLine) {
+	if p.expandInLiterals || p.lexing.language == nil {
+		return
+	}
+	var spans []Span
+	spans, p.lexing.state = p.lexing.language.Lexer.Lex(m.String(), p.lexing.state)
+	m.protect(spans, 0)
+}
+
+type plainLexer struct{}
+
+func (plainLexer) Lex(line string, state LexState) ([]Span, LexState) {
+	return nil, state
+}
+
+// States of cLexer
+const (
+	lexCode LexState = iota
+	lexBlockComment
+	lexRawString
+)
+
+// cLexer handles C-like languages; Go adds raw strings in backquotes
+type cLexer struct {
+	rawStrings bool
+}
+
+func (l cLexer) Lex(line string, state LexState) ([]Span, LexState) {
+	var spans []Span
+	i := 0
+	switch state {
+	case lexBlockComment:
+		end, closed := blockCommentEnd(line, 0)
+		spans = append(spans, Span{0, end, SpanComment})
+		if !closed {
+			return spans, lexBlockComment
+		}
+		i = end
+	case lexRawString:
+		end := strings.IndexByte(line, '`')
+		if end < 0 {
+			return []Span{{0, len(line), SpanString}}, lexRawString
+		}
+		spans = append(spans, Span{0, end + 1, SpanString})
+		i = end + 1
+	}
+	
+	for i < len(line) {
+		switch {
+		case strings.HasPrefix(line[i:], "//"):
+			return append(spans, Span{i, len(line), SpanComment}), lexCode
+		case strings.HasPrefix(line[i:], "/*"):
+			end, closed := blockCommentEnd(line, i+2)
+			spans = append(spans, Span{i, end, SpanComment})
+			if !closed {
+				return spans, lexBlockComment
+			}
+			i = end
+		case line[i] == '"':
+			end := quotedEnd(line, i+1, '"')
+			spans = append(spans, Span{i, end, SpanString})
+			i = end
+		case line[i] == '\'' && !(i > 0 && isDigit(line[i-1])):
+			// A quote after a digit is a C++14 digit separator
+			end := quotedEnd(line, i+1, '\'')
+			spans = append(spans, Span{i, end, SpanChar})
+			i = end
+		case line[i] == '`' && l.rawStrings:
+			end := strings.IndexByte(line[i+1:], '`')
+			if end < 0 {
+				return append(spans, Span{i, len(line), SpanString}), lexRawString
+			}
+			spans = append(spans, Span{i, i + end + 2, SpanString})
+			i += end + 2
+		default:
+			i++
+		}
+	}
+	return spans, lexCode
+}
+
+// States of the Python lexer
+const (
+	lexTripleSingle LexState = iota + 1
+	lexTripleDouble
+)
+
+type pythonLexer struct{}
+
+func (pythonLexer) Lex(line string, state LexState) ([]Span, LexState) {
+	var spans []Span
+	i := 0
+	if state != lexCode {
+		delim := `'''`
+		if state == lexTripleDouble {
+			delim = `"""`
+		}
+		end := strings.Index(line, delim)
+		if end < 0 {
+			return []Span{{0, len(line), SpanString}}, state
+		}
+		spans = append(spans, Span{0, end + 3, SpanString})
+		i = end + 3
+	}
+	
+	for i < len(line) {
+		switch c := line[i]; {
+		case isDynamicMacro(line, i):
+			// Code, not a comment
+			i += 3
+		case c == '#':
+			return append(spans, Span{i, len(line), SpanComment}), lexCode
+		case strings.HasPrefix(line[i:], `'''`) || strings.HasPrefix(line[i:], `"""`):
+			delim := line[i : i+3]
+			end := strings.Index(line[i+3:], delim)
+			if end < 0 {
+				next := lexTripleSingle
+				if c == '"' {
+					next = lexTripleDouble
+				}
+				return append(spans, Span{i, len(line), SpanString}), next
+			}
+			spans = append(spans, Span{i, i + end + 6, SpanString})
+			i += end + 6
+		case c == '"' || c == '\'':
+			end := quotedEnd(line, i+1, c)
+			spans = append(spans, Span{i, end, SpanString})
+			i = end
+		default:
+			i++
+		}
+	}
+	return spans, lexCode
+}
+
+// isDynamicMacro reports whether line[i:] starts with one of the predefined
+// macros ##_, ##$, ##i, ##{ and ##}
+func isDynamicMacro(line string, i int) bool {
+	if !strings.HasPrefix(line[i:], "##") || i+2 >= len(line) {
+		return false
+	}
+	switch line[i+2] {
+	case '_', '$', '{', '}':
+		return true
+	case 'i':
+		return !isIdentRune(firstRune(line[i+3:]))
+	}
+	return false
+}
+
+// blockCommentEnd returns the offset after the */ that closes a comment
+// whose body starts at start, or len(line) if it stays open
+func blockCommentEnd(line string, start int) (int, bool) {
+	if end := strings.Index(line[start:], "*/"); end >= 0 {
+		return start + end + 2, true
+	}
+	return len(line), false
+}
+
+// quotedEnd returns the offset after the unescaped quote that closes a
+// literal whose body starts at start, or len(line) if it is unterminated
+func quotedEnd(line string, start int, quote byte) int {
+	for i := start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(line)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
\ No newline at end of file
@@ -0,0 +1,195 @@
+package opp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLexers(t *testing.T) {
+	tests := []struct {
+		name  string
+		lexer Lexer
+		lines []string
+		spans [][]Span
+	}{
+		{
+			name:  "c strings and comments",
+			lexer: CLexer,
+			lines: []string{`puts("a\"b"); // c`, `x = 'y' + 1'000;`},
+			spans: [][]Span{
+				{{5, 11, SpanString}, {14, 18, SpanComment}},
+				{{4, 7, SpanChar}},
+			},
+		},
+		{
+			name:  "c block comment across lines",
+			lexer: CLexer,
+			lines: []string{"a /* b", "c", "d */ e /* f */ g"},
+			spans: [][]Span{
+				{{2, 6, SpanComment}},
+				{{0, 1, SpanComment}},
+				{{0, 4, SpanComment}, {7, 14, SpanComment}},
+			},
+		},
+		{
+			name:  "go raw string across lines",
+			lexer: GoLexer,
+			lines: []string{"s := `a", "b` + \"c\""},
+			spans: [][]Span{
+				{{5, 7, SpanString}},
+				{{0, 2, SpanString}, {5, 8, SpanString}},
+			},
+		},
+		{
+			name:  "python",
+			lexer: PythonLexer,
+			lines: []string{`s = 'a' + "b"  # c`, `t = """x`, `y""" + z`, `n = ##_ + ##i  ## c`, `##ignored`},
+			spans: [][]Span{
+				{{4, 7, SpanString}, {10, 13, SpanString}, {15, 18, SpanComment}},
+				{{4, 8, SpanString}},
+				{{0, 4, SpanString}},
+				{{15, 19, SpanComment}},
+				{{0, 9, SpanComment}},
+			},
+		},
+		{
+			name:  "plain",
+			lexer: PlainLexer,
+			lines: []string{`"a" // b`},
+			spans: [][]Span{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state LexState
+			for i, line := range tt.lines {
+				var spans []Span
+				spans, state = tt.lexer.Lex(line, state)
+				if !reflect.DeepEqual(spans, tt.spans[i]) {
+					t.Errorf("Lex(%q) = %v, want %v", line, spans, tt.spans[i])
+				}
+			}
+			if state != 0 {
+				t.Errorf("final state = %d, want 0", state)
+			}
+		})
+	}
+}
+
+func TestMacrosSkipLiterals(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		input    string
+		literals bool
+		expected string
+	}{
+		{
+			name:     "c",
+			file:     "main.opp.c",
+			input:    "##:ERROR fail()\nERROR; puts(\"ERROR: disk full\"); // ERROR handling\n/* ERROR\nERROR */ ERROR",
+			expected: "fail(); puts(\"ERROR: disk full\"); // ERROR handling\n/* ERROR\nERROR */ fail()",
+		},
+		{
+			name:     "opt in",
+			file:     "main.opp.c",
+			input:    "##:ERROR fail()\nputs(\"ERROR\"); // ERROR",
+			literals: true,
+			expected: "puts(\"fail()\"); // fail()",
+		},
+		{
+			name:     "go raw string",
+			file:     "main.opp.go",
+			input:    "##:X y\ns := `X\nX` + X",
+			expected: "s := `X\nX` + y",
+		},
+		{
+			name:     "python",
+			file:     "main.opp.py",
+			input:    "##:X y\nX  # X\n'''X\nX''' X",
+			expected: "y  # X\n'''X\nX''' y",
+		},
+		{
+			name:     "python dynamic macros",
+			file:     "main.opp.py",
+			input:    "##:FOO bar\ny = ##_ + FOO  # FOO",
+			expected: "y = -3 + bar  # FOO",
+		},
+		{
+			name:     "plain text",
+			file:     "notes.txt",
+			input:    "##:X y\n\"X\" // X",
+			expected: "\"y\" // y",
+		},
+		{
+			name:     "strings produced by expansion",
+			file:     "main.opp.c",
+			input:    "##:MSG \"X\"\n##:X y\nMSG X",
+			expected: "\"X\" y",
+		},
+		{
+			name:     "strings in arguments",
+			file:     "main.opp.c",
+			input:    "##:f [#0]\n##:X y\nf(\"X\", X)",
+			expected: "[\"X\"]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.input), 0644); err != nil {
+				t.Fatalf("Failed to write input file: %v", err)
+			}
+
+			p := New()
+			p.SetExpandInLiterals(tt.literals)
+			result, err := p.ProcessFile(path)
+			if err != nil {
+				t.Fatalf("ProcessFile() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("ProcessFile() = %q, want %q", result, tt.expected)
+			}
+			if warnings := p.Warnings(); len(warnings) != 0 {
+				t.Errorf("Warnings() = %v, want none", warnings)
+			}
+		})
+	}
+}
+
+func TestSetLanguage(t *testing.T) {
+	p := New()
+	p.SetLanguage(LanguageC)
+	result, err := p.Process("##:X y\nX \"X\"")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "y \"X\"" {
+		t.Errorf("Process() = %q, want %q", result, "y \"X\"")
+	}
+}
+
+func TestLexerStatePerFile(t *testing.T) {
+	tempDir := t.TempDir()
+	header := filepath.Join(tempDir, "header.h")
+	// The comment opened in the header must not swallow the rest of main.c
+	if err := os.WriteFile(header, []byte("X /* X"), 0644); err != nil {
+		t.Fatalf("Failed to write header file: %v", err)
+	}
+	mainFile := filepath.Join(tempDir, "main.c")
+	if err := os.WriteFile(mainFile, []byte("##:X y\n##<header\\.h.\nX"), 0644); err != nil {
+		t.Fatalf("Failed to write main file: %v", err)
+	}
+
+	result, err := New().ProcessFile(mainFile)
+	if err != nil {
+		t.Fatalf("ProcessFile() error = %v", err)
+	}
+	if result != "y /* X\ny" {
+		t.Errorf("ProcessFile() = %q, want %q", result, "y /* X\ny")
+	}
+}
\ No newline at end of file
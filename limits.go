@@ -0,0 +1,99 @@
+package opp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Limits bounds the resources a single run may use. A zero field means no
+// limit. Use them when processing untrusted input.
+type Limits struct {
+	// MaxExpansions bounds the number of macro expansions in a single line
+	MaxExpansions int
+	// MaxOutputBytes bounds the size of the output
+	MaxOutputBytes int64
+	// MaxIncludeDepth bounds the nesting of ##< includes
+	MaxIncludeDepth int
+	// MaxMacros bounds the number of macros defined at the same time,
+	// including the predefined ones
+	MaxMacros int
+}
+
+// Limit identifies one of the fields of Limits
+type Limit int
+
+const (
+	LimitExpansions Limit = iota
+	LimitOutputBytes
+	LimitIncludeDepth
+	LimitMacros
+)
+
+func (l Limit) String() string {
+	switch l {
+	case LimitExpansions:
+		return "macro expansion"
+	case LimitOutputBytes:
+		return "output bytes"
+	case LimitIncludeDepth:
+		return "include depth"
+	case LimitMacros:
+		return "macro count"
+	default:
+		return fmt.Sprintf("Limit(%d)", int(l))
+	}
+}
+
+// LimitError reports that a run hit one of its Limits. It is returned
+// wrapped in an *Error and can be found with errors.As. Hitting a limit
+// always stops processing, even in keep-going mode.
+type LimitError struct {
+	Limit Limit
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// SetLimits sets the resource limits for subsequent runs
+func (p *Preprocessor) SetLimits(limits Limits) {
+	p.limits = limits
+}
+
+// ProcessContext works like Process but stops with the context's error,
+// wrapped in an *Error, when ctx is done
+func (p *Preprocessor) ProcessContext(ctx context.Context, input string) (string, error) {
+	return p.processToString(ctx, strings.NewReader(input), nil)
+}
+
+// ProcessWriterContext works like ProcessWriter but stops with the
+// context's error, wrapped in an *Error, when ctx is done
+func (p *Preprocessor) ProcessWriterContext(ctx context.Context, r io.Reader, w io.Writer) error {
+	return p.process(ctx, r, w, nil)
+}
+
+// limitError returns a positioned error for limit at offset of the current line
+func (p *Preprocessor) limitError(kind DirectiveKind, offset int, limit Limit, max int64) *Error {
+	return p.wrapError(kind, offset, &LimitError{Limit: limit, Max: max})
+}
+
+// checkContext reports cancellation of the running context
+func (p *Preprocessor) checkContext() error {
+	if p.ctx == nil {
+		return nil
+	}
+	if err := p.ctx.Err(); err != nil {
+		return p.wrapError(DirectiveNone, -1, err)
+	}
+	return nil
+}
+
+// isFatal reports whether err must stop processing even in keep-going mode
+func isFatal(err error) bool {
+	var limitErr *LimitError
+	return errors.As(err, &limitErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
\ No newline at end of file
@@ -0,0 +1,142 @@
+package opp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		input  string
+		limit  Limit
+		line   int
+	}{
+		{
+			name:   "expansion count",
+			limits: Limits{MaxExpansions: 10},
+			input:  "ok\n" + doublingMacros(4) + "M0",
+			limit:  LimitExpansions,
+			line:   7,
+		},
+		{
+			name:   "output size",
+			limits: Limits{MaxOutputBytes: 10},
+			input:  "12345\n67890\nmore",
+			limit:  LimitOutputBytes,
+			line:   2,
+		},
+		{
+			name:   "exploding line",
+			limits: Limits{MaxOutputBytes: 1000},
+			input:  doublingMacros(12) + "M0",
+			limit:  LimitOutputBytes,
+			line:   14,
+		},
+		{
+			name:   "macro count",
+			limits: Limits{MaxMacros: 3},
+			input:  "##:A 1\n##:B 2\n##:A 3\n##:C 4",
+			limit:  LimitMacros,
+			line:   4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.SetLimits(tt.limits)
+			_, err := p.Process(tt.input)
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != tt.limit {
+				t.Fatalf("Process() error = %v, want %v limit", err, tt.limit)
+			}
+			var perr *Error
+			if !errors.As(err, &perr) || perr.Pos.Line != tt.line {
+				t.Errorf("Process() error = %v, want position on line %d", err, tt.line)
+			}
+		})
+	}
+}
+
+func TestLimitsWithinBounds(t *testing.T) {
+	p := New()
+	p.SetLimits(Limits{MaxExpansions: 4, MaxOutputBytes: 7, MaxMacros: 3})
+	result, err := p.Process("##:A B\n##:B c\nA A\nA")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "c c\nc" {
+		t.Errorf("Process() = %q, want %q", result, "c c\nc")
+	}
+}
+
+func TestIncludeDepthLimit(t *testing.T) {
+	tempDir := t.TempDir()
+	self := filepath.Join(tempDir, "self.h")
+	if err := os.WriteFile(self, []byte("x\n##<self\\.h."), 0644); err != nil {
+		t.Fatalf("Failed to write include file: %v", err)
+	}
+
+	p := New()
+	p.SetLimits(Limits{MaxIncludeDepth: 5})
+	p.SetKeepGoing(true)
+	_, err := p.ProcessFile(self)
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitIncludeDepth || limitErr.Max != 5 {
+		t.Fatalf("ProcessFile() error = %v, want include depth limit", err)
+	}
+	var perr *Error
+	if errors.As(err, &perr) && len(perr.IncludeChain) != 5 {
+		t.Errorf("include chain has %d entries, want 5", len(perr.IncludeChain))
+	}
+}
+
+func TestProcessContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// M0 needs about 2^40 expansions
+	_, err := New().ProcessContext(ctx, doublingMacros(40)+"M0")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ProcessContext() error = %v, want deadline exceeded", err)
+	}
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Pos.Line != 42 {
+		t.Errorf("ProcessContext() error = %v, want position on line 42", err)
+	}
+}
+
+func TestProcessWriterContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out strings.Builder
+	err := New().ProcessWriterContext(ctx, strings.NewReader("a\nb"), &out)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ProcessWriterContext() error = %v, want canceled", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("output = %q, want nothing", out.String())
+	}
+}
+
+// doublingMacros defines M0 to Mn, where each Mi expands to two copies of
+// the next one and Mn to x
+func doublingMacros(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "##:M%d M%d M%d\n", i, i+1, i+1)
+	}
+	fmt.Fprintf(&b, "##:M%d x\n", n)
+	return b.String()
+}
\ No newline at end of file
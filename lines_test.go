@@ -0,0 +1,158 @@
+package opp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreserveLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		defines  map[string]string
+		expected string
+	}{
+		{
+			name:     "directives become empty lines",
+			input:    "##:A x\nA\n##-A\nA",
+			expected: "\nx\n\nA",
+		},
+		{
+			name:     "false blocks become empty lines",
+			input:    "##~DEBUG|~DEBUG\nrelease\n##@\ndebug\n##.\nend",
+			defines:  map[string]string{"DEBUG": "1"},
+			expected: "\n\n\ndebug\n\nend",
+		},
+		{
+			name:     "blank source lines are kept",
+			input:    "a\n\n\nb\n",
+			expected: "a\n\n\nb\n",
+		},
+		{
+			name:     "leading directive",
+			input:    "##:A x\n\nA",
+			expected: "\n\nx",
+		},
+		{
+			name:     "macro expanding to nothing",
+			input:    "##:dbg\ndbg\ncode",
+			expected: "\n\ncode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.SetPreserveLines(true)
+			for k, v := range tt.defines {
+				p.Define(k, v)
+			}
+
+			result, err := p.Process(tt.input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+			if got, want := strings.Count(result, "\n"), strings.Count(tt.input, "\n"); got != want {
+				t.Errorf("output has %d newlines, input has %d", got, want)
+			}
+		})
+	}
+}
+
+func TestDefaultDropsEmptyLines(t *testing.T) {
+	result, err := New().Process("##:A x\n\nA\n\n")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "x" {
+		t.Errorf("Process() = %q, want %q", result, "x")
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	input := "##:A x\nA\nb\n##~X|~X\nc\n##@\nd\n##.\ne"
+
+	tests := []struct {
+		name     string
+		style    LineDirectiveStyle
+		file     string
+		expected string
+	}{
+		{
+			name:     "go",
+			style:    LineDirectivesGo,
+			file:     "main.opp.go",
+			expected: "//line main.opp.go:2\nx\nb\n//line main.opp.go:5\nc\n//line main.opp.go:9\ne",
+		},
+		{
+			name:     "c",
+			style:    LineDirectivesC,
+			file:     "main.opp.c",
+			expected: "#line 2 \"main.opp.c\"\nx\nb\n#line 5 \"main.opp.c\"\nc\n#line 9 \"main.opp.c\"\ne",
+		},
+		{
+			name:     "go without file name",
+			style:    LineDirectivesGo,
+			expected: "//line :2:1\nx\nb\n//line :5:1\nc\n//line :9:1\ne",
+		},
+		{
+			name:     "c without file name",
+			style:    LineDirectivesC,
+			expected: "#line 2\nx\nb\n#line 5\nc\n#line 9\ne",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.currentFile = tt.file
+			p.SetLineDirectives(tt.style)
+
+			result, err := p.Process(input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLineDirectivesAcrossIncludes(t *testing.T) {
+	tempDir := t.TempDir()
+	header := filepath.Join(tempDir, "header.h")
+	if err := os.WriteFile(header, []byte("h1\nh2"), 0644); err != nil {
+		t.Fatalf("Failed to write header file: %v", err)
+	}
+	mainFile := filepath.Join(tempDir, "main.c")
+	if err := os.WriteFile(mainFile, []byte("a\n##<header\\.h.\nb"), 0644); err != nil {
+		t.Fatalf("Failed to write main file: %v", err)
+	}
+
+	p := New()
+	p.SetLineDirectives(LineDirectivesC)
+	p.SetPreserveLines(true)
+	result, err := p.ProcessFile(mainFile)
+	if err != nil {
+		t.Fatalf("ProcessFile() error = %v", err)
+	}
+
+	expected := strings.Join([]string{
+		`#line 1 "` + mainFile + `"`,
+		"a",
+		`#line 1 "` + header + `"`,
+		"h1",
+		"h2",
+		`#line 3 "` + mainFile + `"`,
+		"b",
+	}, "\n")
+	if result != expected {
+		t.Errorf("ProcessFile() = %q, want %q", result, expected)
+	}
+}
\ No newline at end of file
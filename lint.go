@@ -0,0 +1,222 @@
+package opp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// conditionBlock is an open ##~ block. The conditions are decision
+// diagrams.
+type conditionBlock struct {
+	pos Position
+	// enclosing is true where every enclosing block is taken
+	enclosing int
+	// cond is the condition of the current branch as written, and branch
+	// the condition under which the branch is taken
+	cond     int
+	condExpr Expr
+	branch   int
+	// earlier is true where an earlier branch of the block is taken, and
+	// earlierExprs holds the conditions of those branches
+	earlier      int
+	earlierExprs []Expr
+	// final is set after a bare ##@
+	final bool
+	// invalid is set once a condition of the block or an enclosing one has
+	// a syntax error
+	invalid bool
+}
+
+// branchExpr returns the condition under which the current branch is taken
+func (block *conditionBlock) branchExpr() Expr {
+	expr := And{block.condExpr}
+	for _, x := range block.earlierExprs {
+		expr = append(expr, Not{x})
+	}
+	return expr
+}
+
+// blockWalker follows the conditional blocks of a file symbolically, for
+// every combination of variables at once
+type blockWalker struct {
+	bdd   *bdd
+	stack []*conditionBlock
+}
+
+func newBlockWalker() *blockWalker {
+	return &blockWalker{bdd: newBDD()}
+}
+
+// top returns the innermost open block, or nil
+func (w *blockWalker) top() *conditionBlock {
+	if len(w.stack) == 0 {
+		return nil
+	}
+	return w.stack[len(w.stack)-1]
+}
+
+// reached returns the condition under which lines at the current position
+// are processed. It reports false if a condition involved is invalid.
+func (w *blockWalker) reached() (int, bool) {
+	top := w.top()
+	if top == nil {
+		return bddTrue, true
+	}
+	return w.bdd.and(top.enclosing, top.branch), !top.invalid
+}
+
+// reachedExpr returns the condition of reached as an expression
+func (w *blockWalker) reachedExpr() Expr {
+	var operands And
+	for _, block := range w.stack {
+		operands = append(operands, block.branchExpr())
+	}
+	return Simplify(operands)
+}
+
+// apply updates the open blocks for the directive d
+func (w *blockWalker) apply(d *conditionDirective) error {
+	b := w.bdd
+	top := w.top()
+	switch d.Directive {
+	case DirectiveEnd:
+		if top == nil {
+			return errors.New("no matching conditional to close")
+		}
+		w.stack = w.stack[:len(w.stack)-1]
+		
+	case DirectiveCondition:
+		block := &conditionBlock{pos: d.Pos, invalid: d.invalid, cond: bddFalse, condExpr: Const(false), earlier: bddFalse}
+		block.enclosing, _ = w.reached()
+		if top != nil {
+			block.invalid = block.invalid || top.invalid
+		}
+		if !d.invalid {
+			block.cond = b.condition(d.Condition)
+			block.condExpr = d.Condition.Expr()
+		}
+		block.branch = block.cond
+		w.stack = append(w.stack, block)
+		
+	case DirectiveElse:
+		if top == nil {
+			return errors.New("##@ without matching conditional")
+		}
+		if top.final {
+			// Nothing is taken up to the ##.
+			top.branch = bddFalse
+			top.condExpr = Const(false)
+			return errors.New("##@ after the final ##@ of the block")
+		}
+		top.earlier = b.or(top.earlier, top.cond)
+		top.earlierExprs = append(top.earlierExprs, top.condExpr)
+		switch {
+		case d.invalid:
+			top.invalid = true
+			top.cond, top.condExpr = bddFalse, Const(false)
+		case d.Condition == nil:
+			top.cond, top.condExpr = bddTrue, Const(true)
+			top.final = true
+		default:
+			top.cond, top.condExpr = b.condition(d.Condition), d.Condition.Expr()
+		}
+		top.branch = b.and(top.cond, b.not(top.earlier))
+	}
+	return nil
+}
+
+// Lint analyzes the conditions in r, which is read from filename, without
+// preprocessing it. It warns about conditions that are always or never
+// true, ##@ branches never taken because earlier branches of the same block
+// cover them, and blocks that can never be taken inside the enclosing
+// block.
+// Syntax errors and unbalanced blocks are reported as errors. The
+// diagnostics are sorted by position; the error is only set if r cannot be
+// read.
+func Lint(r io.Reader, filename string) (Diagnostics, error) {
+	var diagnostics Diagnostics
+	report := func(w Warning, pos Position, directive DirectiveKind, format string, args ...interface{}) {
+		severity := SeverityWarning
+		if w == 0 {
+			severity = SeverityError
+		}
+		diagnostics = append(diagnostics, &Diagnostic{
+			Severity: severity,
+			Warning:  w,
+			Error:    &Error{Pos: pos, Directive: directive, Err: fmt.Errorf(format, args...)},
+		})
+	}
+	
+	w := newBlockWalker()
+	b := w.bdd
+	err := scanConditions(r, filename, func(line string, d *conditionDirective) error {
+		if d == nil {
+			return nil
+		}
+		if err := w.apply(d); err != nil {
+			report(0, d.Pos, d.Directive, "%v", err)
+			return nil
+		}
+		block := w.top()
+		if d.Directive == DirectiveEnd || block.invalid {
+			return nil
+		}
+		
+		if d.Condition != nil {
+			switch block.cond {
+			case bddTrue:
+				report(WarnConstantCondition, d.Pos, d.Directive, "condition is always true")
+				return nil
+			case bddFalse:
+				report(WarnConstantCondition, d.Pos, d.Directive, "condition is never true")
+				return nil
+			}
+		}
+		taken := b.and(block.enclosing, block.cond)
+		switch {
+		case taken == bddFalse && len(w.stack) > 1:
+			report(WarnDeadBlock, d.Pos, d.Directive, "branch is never taken: its condition contradicts the enclosing block at %s", w.stack[len(w.stack)-2].pos)
+		case taken == bddFalse:
+			report(WarnUnreachableBranch, d.Pos, d.Directive, "branch is never taken")
+		case b.and(taken, b.not(block.earlier)) == bddFalse:
+			report(WarnUnreachableBranch, d.Pos, d.Directive, "branch is never taken: earlier branches of the block at %s cover it", block.pos)
+		}
+		return nil
+	})
+	
+	if syntaxErrors, ok := err.(Diagnostics); ok {
+		diagnostics = append(diagnostics, syntaxErrors...)
+	} else if err != nil {
+		return nil, err
+	}
+	for _, block := range w.stack {
+		report(0, block.pos, DirectiveCondition, "unclosed conditional block")
+	}
+	
+	sortDiagnostics(diagnostics)
+	return diagnostics, nil
+}
+
+// sortDiagnostics orders diagnostics of a single file by position
+func sortDiagnostics(diagnostics Diagnostics) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i].Pos, diagnostics[j].Pos
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// LintFile works like Lint for the named file
+func LintFile(filename string) (Diagnostics, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, &Error{Pos: Position{File: filename}, Err: err}
+	}
+	defer f.Close()
+	return Lint(f, filename)
+}
\ No newline at end of file
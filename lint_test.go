@@ -0,0 +1,87 @@
+package opp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	input := strings.Join([]string{
+		"##~A|~(~A|~A)",
+		"always",
+		"##.",
+		"##~(~(~A|~A)|~(~B|~B))|~(~(~A|~A)|~(~B|~B))",
+		"##~A",
+		"neither a nor b, yet a",
+		"##.",
+		"##@~A",
+		"a again",
+		"##@~A",
+		"##.",
+		"##~X|~X",
+		"##@",
+		"##@",
+		"##.",
+		"##~A|~A",
+		"fine",
+		"##@",
+		"##.",
+	}, "\n")
+
+	diagnostics, err := Lint(strings.NewReader(input), "main.opp")
+	if err != nil {
+		t.Fatalf("Lint() error = %v", err)
+	}
+	expected := []string{
+		"main.opp:1:1: warning: condition is always true [constant-condition]",
+		"main.opp:5:1: warning: branch is never taken: its condition contradicts the enclosing block at main.opp:4:1 [dead-block]",
+		"main.opp:10:1: warning: branch is never taken: earlier branches of the block at main.opp:4:1 cover it [unreachable-branch]",
+		"main.opp:14:1: error: ##@ after the final ##@ of the block",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Lint() = %v, want %d diagnostics", diagnostics, len(expected))
+	}
+	for i, d := range diagnostics {
+		if d.String() != expected[i] {
+			t.Errorf("diagnostic %d = %q, want %q", i, d, expected[i])
+		}
+	}
+}
+
+func TestLintStructure(t *testing.T) {
+	input := "##.\n##@\n##~(~a\n##@~a|b\n##~a|~a\n##~a|~(~a|~a)\n"
+	diagnostics, err := Lint(strings.NewReader(input), "main.opp")
+	if err != nil {
+		t.Fatalf("Lint() error = %v", err)
+	}
+	expected := []string{
+		"main.opp:1:1: error: no matching conditional to close",
+		"main.opp:2:1: error: ##@ without matching conditional",
+		"main.opp:3:1: error: unclosed conditional block",
+		"main.opp:3:4: error: unclosed (",
+		"main.opp:4:7: error: expected ~, found \"b\"",
+		"main.opp:5:1: error: unclosed conditional block",
+		"main.opp:6:1: error: unclosed conditional block",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Lint() = %v, want %d diagnostics", diagnostics, len(expected))
+	}
+	for i, d := range diagnostics {
+		if d.String() != expected[i] {
+			t.Errorf("diagnostic %d = %q, want %q", i, d, expected[i])
+		}
+	}
+}
+
+func TestBDDSatisfy(t *testing.T) {
+	b := newBDD()
+	cond, _ := ParseCondition("~(~A|~A)|~B")
+	x := b.and(b.condition(cond), b.variable("B"))
+	assignment, ok := b.satisfy(x)
+	if !ok || len(assignment) != 2 || !assignment["A"] || !assignment["B"] {
+		t.Errorf("satisfy() = %v, %v, want A and B defined", assignment, ok)
+	}
+	if _, ok := b.satisfy(b.and(x, b.not(b.variable("A")))); ok {
+		t.Errorf("satisfy() found an assignment for a contradiction")
+	}
+}
\ No newline at end of file
@@ -1,12 +1,15 @@
 package opp
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-func (p *Preprocessor) defineMacro(definition string) error {
+// defineMacro handles the text after ##:; offset is the byte offset of
+// definition within the current line
+func (p *Preprocessor) defineMacro(definition string, offset int) error {
 	// Find first space to separate name from body
 	spaceIdx := strings.Index(definition, " ")
 	if spaceIdx == -1 {
@@ -17,8 +20,10 @@ func (p *Preprocessor) defineMacro(definition string) error {
 			// Extract just the name part
 			name = name[:parenIdx]
 		}
-		p.macros[name] = &Macro{Name: name, Definition: ""}
-		return nil
+		if name == "" {
+			return p.errorf(DirectiveDefine, offset, "missing macro name")
+		}
+		return p.setMacro(&Macro{Name: name, Definition: ""}, offset)
 	}
 	
 	nameAndArgs := definition[:spaceIdx]
@@ -41,15 +46,60 @@ func (p *Preprocessor) defineMacro(definition string) error {
 		}
 	}
 	
+	if name == "" {
+		return p.errorf(DirectiveDefine, offset, "missing macro name")
+	}
+	
 	// Process ##,# escapes in the macro body
 	body = p.handleNestedMacroEscapes(body)
 	
-	p.macros[name] = &Macro{
+	return p.setMacro(&Macro{
 		Name:           name,
 		Definition:     body,
 		IsFunctionLike: isFunctionLike,
+	}, offset)
+}
+
+// setMacro installs a macro defined by ##: at offset of the current line,
+// warning about the definition it replaces
+func (p *Preprocessor) setMacro(m *Macro, offset int) error {
+	old, ok := p.macros[m.Name]
+	if limit := p.limits.MaxMacros; limit > 0 && !ok && len(p.macros) >= limit {
+		return p.limitError(DirectiveDefine, offset, LimitMacros, int64(limit))
+	}
+	if ok {
+		err := p.warn(WarnRedefine, p.errorf(DirectiveDefine, offset, "macro %s redefined", m.Name))
+		if err != nil {
+			return err
+		}
+		if err := p.warnUnused(old); err != nil {
+			return err
+		}
 	}
 	
+	m.pos = p.position(offset)
+	m.includeChain = p.includeChain()
+	p.macros[m.Name] = m
+	p.macroMatcher = nil
+	return nil
+}
+
+// undefineMacro handles ##-name at offset of the current line
+func (p *Preprocessor) undefineMacro(name string, offset int) error {
+	macro, isMacro := p.macros[name]
+	_, isVariable := p.variables[name]
+	if !isMacro && !isVariable {
+		err := p.warn(WarnUndefineUnknown, p.errorf(DirectiveUndefine, offset, "%s is not defined", name))
+		if err != nil {
+			return err
+		}
+	}
+	if isMacro {
+		if err := p.warnUnused(macro); err != nil {
+			return err
+		}
+	}
+	p.Undefine(name)
 	return nil
 }
 
@@ -97,15 +147,24 @@ func (p *Preprocessor) handleNestedMacroEscapes(line string) string {
 // processStringizeCharize handles #" and #' operators in macro definitions
 // Returns the processed string with stringize/charize applied
 func (p *Preprocessor) processStringizeCharize(definition string, args []string) string {
+	result, _ := p.substituteArgs(definition, args)
+	return result
+}
+
+// substituteArgs does the work of processStringizeCharize. It also returns
+// the number of arguments the definition refers to with #N, #"#N or #'#N,
+// which is more than len(args) when the call supplied too few.
+func (p *Preprocessor) substituteArgs(definition string, args []string) (string, int) {
 	result := ""
+	needed := 0
 	i := 0
 	
 	for i < len(definition) {
 		// Check for stringize operator #"
-		if i+3 < len(definition) && definition[i:i+2] == "#\"" && definition[i+2] == '#' {
-			// Look for the digit after #"#
-			if i+3 < len(definition) && definition[i+3] >= '0' && definition[i+3] <= '9' {
-				argNum := int(definition[i+3] - '0')
+		if i+2 < len(definition) && definition[i:i+2] == "#\"" && definition[i+2] == '#' {
+			// Look for the index after #"#
+			if argNum, end, ok := parseArgIndex(definition, i+3); ok {
+				needed = max(needed, argNum+1)
 				if argNum < len(args) {
 					// Escape quotes and backslashes in the argument
 					escaped := strings.ReplaceAll(args[argNum], "\\", "\\\\")
@@ -115,16 +174,16 @@ func (p *Preprocessor) processStringizeCharize(definition string, args []string)
 					// Argument index out of bounds - empty string
 					result += "\"\""
 				}
-				i += 4 // Skip #"#N
+				i = end // Skip #"#N
 				continue
 			}
 		}
 		
 		// Check for charize operator #'
-		if i+3 < len(definition) && definition[i:i+2] == "#'" && definition[i+2] == '#' {
-			// Look for the digit after #'#
-			if i+3 < len(definition) && definition[i+3] >= '0' && definition[i+3] <= '9' {
-				argNum := int(definition[i+3] - '0')
+		if i+2 < len(definition) && definition[i:i+2] == "#'" && definition[i+2] == '#' {
+			// Look for the index after #'#
+			if argNum, end, ok := parseArgIndex(definition, i+3); ok {
+				needed = max(needed, argNum+1)
 				if argNum < len(args) {
 					// Escape quotes and backslashes in the argument
 					escaped := strings.ReplaceAll(args[argNum], "\\", "\\\\")
@@ -134,38 +193,38 @@ func (p *Preprocessor) processStringizeCharize(definition string, args []string)
 					// Argument index out of bounds - empty string
 					result += "''"
 				}
-				i += 4 // Skip #'#N
+				i = end // Skip #'#N
 				continue
 			}
 		}
 		
 		// Check for varargs ##N..n
-		if i+6 <= len(definition) && definition[i:i+2] == "##" && 
-		   definition[i+2] >= '0' && definition[i+2] <= '9' &&
-		   definition[i+3:i+6] == "..n" {
-			startArg := int(definition[i+2] - '0')
-			
-			// Collect arguments from startArg onwards
-			var varargsResult []string
-			for j := startArg; j < len(args); j++ {
-				varargsResult = append(varargsResult, args[j])
+		if strings.HasPrefix(definition[i:], "##") {
+			if startArg, end, ok := parseArgIndex(definition, i+2); ok && strings.HasPrefix(definition[end:], "..n") {
+				// Collect arguments from startArg onwards
+				var varargsResult []string
+				for j := startArg; j < len(args); j++ {
+					varargsResult = append(varargsResult, args[j])
+				}
+				
+				// Join with ", " (comma + space)
+				result += strings.Join(varargsResult, ", ")
+				i = end + 3 // Skip ##N..n
+				continue
 			}
-			
-			// Join with ", " (comma + space)
-			result += strings.Join(varargsResult, ", ")
-			i += 6 // Skip ##N..n
-			continue
 		}
 		
 		// Check for regular argument substitution #N
-		if i+1 < len(definition) && definition[i] == '#' && definition[i+1] >= '0' && definition[i+1] <= '9' {
-			argNum := int(definition[i+1] - '0')
-			if argNum < len(args) {
-				result += args[argNum]
+		if definition[i] == '#' {
+			if argNum, end, ok := parseArgIndex(definition, i+1); ok {
+				needed = max(needed, argNum+1)
+				if argNum < len(args) {
+					result += args[argNum]
+				}
+				// If arg doesn't exist, #N remains as is
+				i = end // Skip #N
+				continue
 			}
-			// If arg doesn't exist, #N remains as is
-			i += 2 // Skip #N
-			continue
 		}
 		
 		// Regular character
@@ -173,7 +232,7 @@ func (p *Preprocessor) processStringizeCharize(definition string, args []string)
 		i++
 	}
 	
-	return result
+	return result, needed
 }
 
 // parseMacroCall attempts to parse a function-like macro call
@@ -223,130 +282,238 @@ func parseMacroCall(text string, pos int, macroName string) []string {
 	return args
 }
 
-func (p *Preprocessor) expandMacros(line string) (string, error) {
-	result := line
-	changed := true
+// expandMacros expands the macros in line. The result of each expansion is
+// rescanned together with the rest of the line, but a macro is never
+// expanded again inside text produced by its own expansion, so self
+// referential and mutually recursive macros terminate like they do in C.
+func (p *Preprocessor) expandMacros(line string) (*mappedLine, error) {
+	current := newMappedLine(line)
+	p.lexLine(current)
+	
+	expansions := 0
+	result, err := p.expandText(current, &expansions)
+	if err != nil {
+		return nil, err
+	}
 	
-	// Keep expanding until no more changes (handles nested macros)
-	for changed {
-		changed = false
-		newResult := ""
-		i := 0
+	// Expand predefined dynamic macros
+	return p.expandDynamicMacros(result)
+}
+
+// expandText expands the macros in current, counting each expansion
+func (p *Preprocessor) expandText(current *mappedLine, expansions *int) (*mappedLine, error) {
+	result := &mappedLine{}
+	text := current.String()
+	i := 0
+	
+	for i < len(text) {
+		at := current.origins[i]
+		found := false
+		if at.literal {
+			result.appendSource(current, i, i+1)
+			i++
+			continue
+		}
 		
-		for i < len(result) {
-			found := false
+		// Try the macros whose names start here, longest first
+		for _, macro := range p.matcher().matches(text, i) {
+			name := macro.Name
+			if at.hidden.contains(name) {
+				continue
+			}
 			
-			// Try each macro
-			for name, macro := range p.macros {
-				if i+len(name) <= len(result) && result[i:i+len(name)] == name {
-					if macro.IsFunctionLike {
-						// Function-like macro - only expand if followed by (
-						args := parseMacroCall(result, i, name)
-						if args != nil {
-							// Function-like macro invocation
-							endPos := i + len(name)
-							parenCount := 1
-							endPos++ // Skip opening (
-							for endPos < len(result) && parenCount > 0 {
-								if result[endPos] == '(' {
-									parenCount++
-								} else if result[endPos] == ')' {
-									parenCount--
-								}
-								endPos++
-							}
-							
-							// Process the macro definition with arguments
-							expanded := p.processStringizeCharize(macro.Definition, args)
-							newResult += expanded
-							i = endPos
-							found = true
-							changed = true
-							break
-						}
-					} else {
-						// Object-like macro - check word boundary
-						if !isAlphaNum(getCharAt(result, i+len(name))) {
-							// Simple macro replacement (not followed by alnum)
-							newResult += macro.Definition
-							i += len(name)
-							found = true
-							changed = true
-							break
-						}
+			// A name must not start in the middle of an identifier
+			before := lastRune(text[:i])
+			if i == 0 {
+				before, _ = utf8.DecodeLastRune(result.text)
+			}
+			if isIdentRune(firstRune(name)) && isIdentRune(before) {
+				continue
+			}
+			
+			expanded := ""
+			endPos := i + len(name)
+			if macro.IsFunctionLike {
+				// Function-like macro - only expand if followed by (
+				args := parseMacroCall(text, i, name)
+				if args == nil {
+					continue
+				}
+				parenCount := 1
+				endPos++ // Skip opening (
+				for endPos < len(text) && parenCount > 0 {
+					if text[endPos] == '(' {
+						parenCount++
+					} else if text[endPos] == ')' {
+						parenCount--
 					}
+					endPos++
 				}
+				
+				// Arguments are fully expanded before they are substituted
+				for j, arg := range args {
+					argLine := uniformLine(arg, at)
+					p.protectLiterals(argLine, 0, len(arg))
+					expandedArg, err := p.expandText(argLine, expansions)
+					if err != nil {
+						return nil, err
+					}
+					args[j] = expandedArg.String()
+				}
+				
+				// Process the macro definition with arguments
+				var needed int
+				expanded, needed = p.substituteArgs(macro.Definition, args)
+				if needed > len(args) {
+					// Calls produced by other expansions have no source column
+					offset := -1
+					if at.name == "" {
+						offset = at.column
+					}
+					err := p.warn(WarnMissingArguments, p.errorf(DirectiveNone, offset,
+						"macro %s called with %d arguments but uses #%d", name, len(args), needed-1))
+					if err != nil {
+						return nil, err
+					}
+				}
+			} else {
+				// Object-like macro - nor end in the middle of one
+				if isIdentRune(lastRune(name)) && isIdentRune(firstRune(text[endPos:])) {
+					continue
+				}
+				expanded = macro.Definition
 			}
+			macro.used = true
 			
-			if !found {
-				newResult += string(result[i])
-				i++
+			*expansions++
+			if limit := p.limits.MaxExpansions; limit > 0 && *expansions > limit {
+				return nil, p.limitError(DirectiveNone, -1, LimitExpansions, int64(limit))
+			}
+			if err := p.checkContext(); err != nil {
+				return nil, err
 			}
+			
+			// Rescan the expansion followed by the rest of the line
+			next := &mappedLine{}
+			next.appendExpansion(expanded, at, name)
+			p.protectLiterals(next, 0, len(expanded))
+			next.appendSource(current, endPos, len(current.text))
+			current = next
+			text = current.String()
+			i = 0
+			found = true
+			
+			// A line that alone exceeds the output limit need not be expanded further
+			if limit := p.limits.MaxOutputBytes; limit > 0 && int64(len(result.text)+len(current.text)) > limit {
+				return nil, p.limitError(DirectiveNone, -1, LimitOutputBytes, limit)
+			}
+			break
 		}
 		
-		result = newResult
+		if !found {
+			// Copy whole runes so that no name can match inside one
+			_, size := utf8.DecodeRuneInString(text[i:])
+			result.appendSource(current, i, i+size)
+			i += size
+		}
 	}
 	
-	// Expand predefined dynamic macros
-	result = p.expandDynamicMacros(result)
-	
 	return result, nil
 }
 
 // Helper functions
-func getCharAt(s string, i int) byte {
-	if i < len(s) {
-		return s[i]
-	}
-	return 0
+
+// isIdentRune reports whether r can be part of an identifier
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
 }
 
-func isAlphaNum(c byte) bool {
-	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+// firstRune returns the first rune of s, or utf8.RuneError if s is empty
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
 }
 
-func (p *Preprocessor) expandDynamicMacros(line string) string {
-	result := line
-	
-	// ##_ - current line number minus 5
-	if strings.Contains(result, "##_") {
-		result = strings.ReplaceAll(result, "##_", strconv.Itoa(p.lineNumber-5))
+// lastRune returns the last rune of s, or utf8.RuneError if s is empty
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func (p *Preprocessor) expandDynamicMacros(line *mappedLine) (*mappedLine, error) {
+	text := line.String()
+	if !strings.Contains(text, "##") {
+		return line, nil
 	}
 	
-	// ##$ - pseudo-random number
-	if strings.Contains(result, "##$") {
-		result = strings.ReplaceAll(result, "##$", strconv.Itoa(p.random.Next()))
-	}
+	result := &mappedLine{}
+	bracesInLine := 0
+	random := ""
 	
-	// ##{ - number of { braces seen so far (including in current line up to the token)
-	for strings.Contains(result, "##{") {
-		idx := strings.Index(result, "##{")
-		if idx >= 0 {
-			// Count braces in the current line up to this point
-			bracesInLine := 0
-			for i := 0; i < idx; i++ {
-				if result[i] == '{' {
-					bracesInLine++
+	for i := 0; i < len(text); {
+		token := text[i:min(i+3, len(text))]
+		value := ""
+		switch {
+		case token == "##i" && p.isPredefined("##i") && !isIdentRune(firstRune(text[i+3:])):
+			// ##i - the imaginary unit of the target language
+			language := p.currentLanguage()
+			if language.ImaginaryUnit == "" {
+				err := p.errorf(DirectivePredefined, line.origins[i].column, "##i is not available in %s", language.Name)
+				if !p.tolerate(err) {
+					return nil, err
 				}
+				// Keep going with the ##i left as it is
+				result.appendSource(line, i, i+3)
+				i += 3
+				continue
 			}
-			// Replace just this occurrence
-			result = result[:idx] + strconv.Itoa(p.braceCount+bracesInLine) + result[idx+3:]
+			value = language.ImaginaryUnit
+		case token == "##_":
+			// ##_ - current line number minus 5
+			value = strconv.Itoa(p.lineNumber - 5)
+		case token == "##$":
+			// ##$ - pseudo-random number, the same for every ##$ in a line
+			if random == "" {
+				random = strconv.Itoa(p.random.Next())
+			}
+			value = random
+		case token == "##{":
+			// ##{ - number of { braces seen so far (including in current line up to the token)
+			value = strconv.Itoa(p.braceCount + bracesInLine)
+		case token == "##}":
+			// ##} - number of } braces modulo 5
+			value = strconv.Itoa(p.closeBraces % 5)
+		default:
+			if text[i] == '{' {
+				bracesInLine++
+			}
+			result.appendSource(line, i, i+1)
+			i++
+			continue
 		}
+		result.appendExpansion(value, line.origins[i], token)
+		i += len(token)
 	}
 	
-	// ##} - number of } braces modulo 5
-	if strings.Contains(result, "##}") {
-		result = strings.ReplaceAll(result, "##}", strconv.Itoa(p.closeBraces%5))
-	}
-	
-	return result
+	return result, nil
 }
 
-func (p *Preprocessor) expandPredefinedMacro(line string) (string, error) {
+// isPredefined reports whether the predefined macro name is still in place,
+// neither undefined nor redefined
+func (p *Preprocessor) isPredefined(name string) bool {
+	m, ok := p.macros[name]
+	return ok && m.predefined
+}
+
+func (p *Preprocessor) expandPredefinedMacro(line string, offset int) (string, error) {
 	// Handle standalone predefined macros
 	switch line {
 	case "##i":
-		return "1i", nil
+		language := p.currentLanguage()
+		if language.ImaginaryUnit == "" {
+			return "", p.errorf(DirectivePredefined, offset, "##i is not available in %s", language.Name)
+		}
+		return language.ImaginaryUnit, nil
 	case "##_":
 		return strconv.Itoa(p.lineNumber - 5), nil
 	case "##$":
@@ -356,7 +523,7 @@ func (p *Preprocessor) expandPredefinedMacro(line string) (string, error) {
 	case "##}":
 		return strconv.Itoa(p.closeBraces % 5), nil
 	default:
-		return "", fmt.Errorf("unknown directive: %s", line)
+		return "", p.errorf(DirectiveUnknown, offset, "unknown directive: %s", line)
 	}
 }
 
@@ -367,9 +534,12 @@ func hasUnescapedArgReferences(body string) bool {
 		return true
 	}
 	
-	// Look for argument references #N (but not ##,#N which are escaped)
+	// Look for argument references #N or #[N] (but not ##,#N which are escaped)
 	for i := 0; i < len(body)-1; i++ {
-		if body[i] == '#' && body[i+1] >= '0' && body[i+1] <= '9' {
+		if body[i] != '#' {
+			continue
+		}
+		if _, _, ok := parseArgIndex(body, i+1); ok {
 			// Found #N - check if it's escaped (preceded by ##,)
 			if i >= 3 && body[i-3:i] == "##," {
 				// This is ##,#N - escaped, don't count it
@@ -383,3 +553,29 @@ func hasUnescapedArgReferences(body string) bool {
 	return false
 }
 
+// parseArgIndex parses the argument index at s[i:], as used after # in #N,
+// #"#N, #'#N and ##N..n. The index is all the digits there, so #12 is
+// argument 12, or digits in brackets, so #[1]2 is argument 1 followed by a
+// literal 2. It returns the index and the position after it.
+func parseArgIndex(s string, i int) (int, int, bool) {
+	bracketed := i < len(s) && s[i] == '['
+	start := i
+	if bracketed {
+		start++
+	}
+	end := start
+	for end < len(s) && isDigit(s[end]) {
+		end++
+	}
+	if end == start || (bracketed && (end == len(s) || s[end] != ']')) {
+		return 0, i, false
+	}
+	n, err := strconv.Atoi(s[start:end])
+	if err != nil {
+		return 0, i, false
+	}
+	if bracketed {
+		end++
+	}
+	return n, end, true
+}
\ No newline at end of file
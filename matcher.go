@@ -0,0 +1,68 @@
+package opp
+
+// macroMatcher finds the macro names that start at a position of a line. It
+// is a trie over the bytes of all names, so a lookup costs at most the
+// length of the longest name, however many macros are defined.
+type macroMatcher struct {
+	root trieNode
+}
+
+type trieNode struct {
+	children map[byte]*trieNode
+	// macro is the macro whose name ends at this node, if any
+	macro *Macro
+}
+
+// newMacroMatcher builds a matcher for macros
+func newMacroMatcher(macros map[string]*Macro) *macroMatcher {
+	m := &macroMatcher{}
+	for name, macro := range macros {
+		if macro.predefined {
+			continue
+		}
+		node := &m.root
+		for i := 0; i < len(name); i++ {
+			child, ok := node.children[name[i]]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[byte]*trieNode)
+				}
+				child = &trieNode{}
+				node.children[name[i]] = child
+			}
+			node = child
+		}
+		node.macro = macro
+	}
+	return m
+}
+
+// matches returns the macros whose names start at text[pos], longest first
+func (m *macroMatcher) matches(text string, pos int) []*Macro {
+	var found []*Macro
+	node := &m.root
+	for i := pos; i < len(text); i++ {
+		node = node.children[text[i]]
+		if node == nil {
+			break
+		}
+		if node.macro != nil {
+			found = append(found, node.macro)
+		}
+	}
+	
+	// Reverse so that the longest name comes first
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found
+}
+
+// matcher returns the matcher for the current macro table, rebuilding it
+// after the table changed
+func (p *Preprocessor) matcher() *macroMatcher {
+	if p.macroMatcher == nil {
+		p.macroMatcher = newMacroMatcher(p.macros)
+	}
+	return p.macroMatcher
+}
\ No newline at end of file
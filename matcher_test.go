@@ -0,0 +1,123 @@
+package opp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLongestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "longer name wins",
+			input:    "##:LOG log\n##:LOG_ERR err\nLOG_ERR LOG",
+			expected: "err log",
+		},
+		{
+			name:     "defined in either order",
+			input:    "##:LOG_ERR err\n##:LOG log\nLOG_ERR LOG",
+			expected: "err log",
+		},
+		{
+			name:     "multi-byte prefix",
+			input:    "##:§ one\n##:§§ two\n§§ §",
+			expected: "two one",
+		},
+		{
+			name:     "function-like falls back to object-like",
+			input:    "##:A a\n##:AB [#0]\nAB A",
+			expected: "AB a",
+		},
+		{
+			name:     "undefine rebuilds the table",
+			input:    "##:LOG log\n##:LOG_ERR err\n##-LOG_ERR\nLOG_ERR LOG",
+			expected: "LOG_ERR log",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map order is random, so repeat to catch nondeterminism
+			for run := 0; run < 20; run++ {
+				result, err := New().Process(tt.input)
+				if err != nil {
+					t.Fatalf("Process() error = %v", err)
+				}
+				if result != tt.expected {
+					t.Fatalf("Process() = %q, want %q", result, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestMatcherManyMacros(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "##:M%d m%d\n", i, i)
+	}
+	input.WriteString("M0 M999 M5000")
+
+	result, err := New().Process(input.String())
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "m0 m999 M5000" {
+		t.Errorf("Process() = %q, want %q", result, "m0 m999 M5000")
+	}
+}
+
+func TestIdentifierBoundaries(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "object-like",
+			input:    "##:DEBUG 1\nXDEBUG MY_DEBUG DEBUG DEBUGX DEBUG_ (DEBUG)",
+			expected: "XDEBUG MY_DEBUG 1 DEBUGX DEBUG_ (1)",
+		},
+		{
+			name:     "function-like",
+			input:    "##:max(a,b) M\nimax(1,2) max(1,2)",
+			expected: "imax(1,2) M",
+		},
+		{
+			name:     "unicode letters",
+			input:    "##:DEBUG 1\nαDEBUG DEBUGß DEBUG",
+			expected: "αDEBUG DEBUGß 1",
+		},
+		{
+			name:     "multi-byte name",
+			input:    "##:ä ae\nbär ä äb",
+			expected: "bär ae äb",
+		},
+		{
+			name:     "symbol names need no boundary",
+			input:    "##:§ ((#0<#1)?#1:#0)\nx=§(a,b)§(c,d)",
+			expected: "x=((a<b)?b:a)((c<d)?d:c)",
+		},
+		{
+			name:     "boundary against earlier expansion",
+			input:    "##:§ x\n##:DEBUG 1\n§DEBUG",
+			expected: "xDEBUG",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := New().Process(tt.input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
\ No newline at end of file
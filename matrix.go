@@ -0,0 +1,186 @@
+package opp
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// MatrixRun is the result of preprocessing a file under one combination of
+// variables
+type MatrixRun struct {
+	// Defined lists the variables defined for the run
+	Defined []string
+	// Output is only kept if RunMatrix is asked to
+	Output string
+	Err    error
+	// SameAs is the index of the first successful run with the same
+	// output, or -1 if there is none or the run failed
+	SameAs int
+	
+	// hash identifies the output
+	hash [sha256.Size]byte
+}
+
+// ConditionVariables returns the variables tested by the ##~ and ##@
+// conditions in r, which is read from filename, and in the files it
+// includes, in order of first appearance. Every ##< is followed, whether or
+// not its block is taken; files that cannot be read are skipped. Conditions
+// with syntax errors are left out and reported as Diagnostics.
+func ConditionVariables(r io.Reader, filename string) ([]string, error) {
+	c := &variableCollector{seen: map[string]bool{}, files: map[string]bool{}}
+	if err := c.scan(r, filename); err != nil {
+		return c.names, err
+	}
+	if len(c.diagnostics) > 0 {
+		return c.names, c.diagnostics
+	}
+	return c.names, nil
+}
+
+// variableCollector gathers the condition variables of a file and its
+// includes
+type variableCollector struct {
+	names       []string
+	seen        map[string]bool
+	files       map[string]bool
+	diagnostics Diagnostics
+}
+
+// scan collects the variables of r, which is read from filename
+func (c *variableCollector) scan(r io.Reader, filename string) error {
+	c.files[filename] = true
+	err := scanConditions(r, filename, func(line string, d *conditionDirective) error {
+		if d != nil && d.Condition != nil {
+			for _, name := range d.Condition.Variables() {
+				if !c.seen[name] {
+					c.seen[name] = true
+					c.names = append(c.names, name)
+				}
+			}
+		}
+		if directive := strings.TrimSpace(line); strings.HasPrefix(directive, "##<") && strings.HasSuffix(directive, ".") {
+			return c.include(unescapeFilename(directive[3:len(directive)-1]), filename)
+		}
+		return nil
+	})
+	if diagnostics, ok := err.(Diagnostics); ok {
+		c.diagnostics = append(c.diagnostics, diagnostics...)
+		return nil
+	}
+	return err
+}
+
+// include collects the variables of a file included from includer, which
+// is looked up like processInclude does
+func (c *variableCollector) include(filename, includer string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		f, err = os.Open(filepath.Join(filepath.Dir(includer), filename))
+	}
+	if err != nil {
+		// The runs report it where it matters
+		return nil
+	}
+	defer f.Close()
+	
+	fullPath := filename
+	if !filepath.IsAbs(filename) {
+		fullPath = filepath.Join(filepath.Dir(includer), filename)
+	}
+	if c.files[fullPath] {
+		return nil
+	}
+	return c.scan(f, fullPath)
+}
+
+// Combinations returns every subset of variables, starting with the empty
+// one; variables[i] is in subset n if bit i of n is set
+func Combinations(variables []string) [][]string {
+	combinations := make([][]string, 1<<len(variables))
+	for n := range combinations {
+		combination := []string{}
+		for i, name := range variables {
+			if n&(1<<i) != 0 {
+				combination = append(combination, name)
+			}
+		}
+		combinations[n] = combination
+	}
+	return combinations
+}
+
+// RunMatrix preprocesses filename once for every combination, with the
+// variables of the combination defined with DefineVariable. Each run uses
+// a fresh preprocessor from newPreprocessor, so options and defines common
+// to all runs go there. Up to parallel runs execute at a time, GOMAXPROCS if
+// parallel is less than 1. Outputs are compared by hash and only kept in
+// the runs if keepOutput is set. The results are in the order of
+// combinations.
+func RunMatrix(ctx context.Context, filename string, combinations [][]string, parallel int, keepOutput bool, newPreprocessor func() *Preprocessor) []*MatrixRun {
+	if parallel < 1 {
+		parallel = runtime.GOMAXPROCS(0)
+	}
+	runs := make([]*MatrixRun, len(combinations))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				p := newPreprocessor()
+				for _, name := range combinations[n] {
+					p.DefineVariable(name)
+				}
+				run := &MatrixRun{Defined: combinations[n], SameAs: -1}
+				run.Output, run.hash, run.Err = p.processFileHash(ctx, filename, keepOutput)
+				runs[n] = run
+			}
+		}()
+	}
+	for n := range combinations {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+	
+	first := map[[sha256.Size]byte]int{}
+	for n, run := range runs {
+		if run.Err != nil {
+			continue
+		}
+		if same, ok := first[run.hash]; ok {
+			run.SameAs = same
+		} else {
+			first[run.hash] = n
+		}
+	}
+	return runs
+}
+
+// processFileHash works like ProcessFile but stops when ctx is done. It
+// returns the hash of the output, and the output itself only if keep is set.
+func (p *Preprocessor) processFileHash(ctx context.Context, filename string, keep bool) (string, [sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := p.openSourceFile(filename)
+	if err != nil {
+		return "", sum, err
+	}
+	defer f.Close()
+	
+	h := sha256.New()
+	var output strings.Builder
+	var w io.Writer = h
+	if keep {
+		w = io.MultiWriter(h, &output)
+	}
+	err = p.process(ctx, f, w, nil)
+	h.Sum(sum[:0])
+	return output.String(), sum, err
+}
\ No newline at end of file
@@ -0,0 +1,115 @@
+package opp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConditionVariables(t *testing.T) {
+	input := "##~(~A|~A)|~B\n##@~C|~C\n##@\n##.\n##~(~B)|~(~D\n##.\n##~E|~E\n##."
+	names, err := ConditionVariables(strings.NewReader(input), "main.opp")
+	if got := strings.Join(names, " "); got != "A B C E" {
+		t.Errorf("ConditionVariables() = %q, want %q", got, "A B C E")
+	}
+	if _, ok := err.(Diagnostics); !ok {
+		t.Errorf("ConditionVariables() error = %v, want Diagnostics", err)
+	}
+}
+
+func TestConditionVariablesInIncludes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"footer.h": "##~INCLUDE_FOOTER\n##<footer\\.h.\n##~A\n##.\n##.",
+		"main.opp": "##~A\n##<footer\\.h.\n##<missing\\.h.\n##.\n##~B|~B\n##.",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mainFile := filepath.Join(dir, "main.opp")
+	names, err := ConditionVariables(strings.NewReader(files["main.opp"]), mainFile)
+	if err != nil {
+		t.Fatalf("ConditionVariables() error = %v", err)
+	}
+	if got := strings.Join(names, " "); got != "A INCLUDE_FOOTER B" {
+		t.Errorf("ConditionVariables() = %q, want %q", got, "A INCLUDE_FOOTER B")
+	}
+}
+
+func TestCombinations(t *testing.T) {
+	var got []string
+	for _, c := range Combinations([]string{"A", "B"}) {
+		got = append(got, "["+strings.Join(c, " ")+"]")
+	}
+	if strings.Join(got, ",") != "[],[A],[B],[A B]" {
+		t.Errorf("Combinations() = %v", got)
+	}
+}
+
+func TestRunMatrix(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "main.opp")
+	input := strings.Join([]string{
+		"##~DEBUG",
+		"debug",
+		"##.",
+		"##~WINDOWS|~WINDOWS",
+		"##~VERBOSE|~VERBOSE",
+		"quiet",
+		"##@",
+		"##<missing.h.",
+		"##.",
+		"##.",
+	}, "\n")
+	if err := os.WriteFile(mainFile, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	variables, err := ConditionVariables(strings.NewReader(input), mainFile)
+	if err != nil {
+		t.Fatalf("ConditionVariables() error = %v", err)
+	}
+	runs := RunMatrix(context.Background(), mainFile, Combinations(variables), 3, false, New)
+	if len(runs) != 8 {
+		t.Fatalf("RunMatrix() returned %d runs, want 8", len(runs))
+	}
+
+	// Only VERBOSE without WINDOWS includes the missing file
+	for n, run := range runs {
+		defined := strings.Join(run.Defined, " ")
+		failing := strings.Contains(" "+defined+" ", " VERBOSE ") && !strings.Contains(defined, "WINDOWS")
+		if (run.Err != nil) != failing {
+			t.Errorf("run %d [%s] error = %v", n, defined, run.Err)
+		}
+	}
+
+	// VERBOSE makes no difference once WINDOWS is defined
+	expected := []int{-1, -1, -1, -1, -1, -1, 2, 3}
+	for n, run := range runs {
+		if run.SameAs != expected[n] {
+			t.Errorf("run %d [%s] SameAs = %d, want %d", n, strings.Join(run.Defined, " "), run.SameAs, expected[n])
+		}
+	}
+}
+
+func TestRunMatrixKeepOutput(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "main.opp")
+	if err := os.WriteFile(mainFile, []byte("##~DEBUG\ndebug\n##.\nmain"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := RunMatrix(context.Background(), mainFile, [][]string{{}, {"DEBUG"}}, 1, true, New)
+	if runs[0].Output != "main" || runs[1].Output != "debug\nmain" {
+		t.Errorf("RunMatrix() outputs = %q, %q", runs[0].Output, runs[1].Output)
+	}
+	runs = RunMatrix(context.Background(), mainFile, [][]string{{}, {"DEBUG"}}, 1, false, New)
+	if runs[0].Output != "" || runs[1].Output != "" || runs[1].SameAs != -1 {
+		t.Errorf("RunMatrix() = %+v, %+v, want no outputs kept", runs[0], runs[1])
+	}
+}
\ No newline at end of file
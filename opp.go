@@ -2,7 +2,11 @@
 package opp
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -16,6 +20,35 @@ type Preprocessor struct {
 	braceCount  int
 	closeBraces int
 	currentFile string
+	
+	// macroMatcher looks up macro names; it is nil after macros changed
+	macroMatcher *macroMatcher
+	
+	// includeStack holds the positions of the ##< directives being processed
+	includeStack []Position
+	
+	keepGoing        bool
+	preserveLines    bool
+	lineDirectives   LineDirectiveStyle
+	limits           Limits
+	language         *Language
+	expandInLiterals bool
+	disabledWarnings map[Warning]bool
+	warningsAsErrors bool
+	
+	// lookupEnv finds condition variables outside the preprocessor, under
+	// envPrefix; nil if disabled
+	lookupEnv func(string) (string, bool)
+	envPrefix string
+	
+	// compat selects the original C++ behaviour, see SetCompat
+	compat Compat
+	
+	// State of the running Process call
+	ctx         context.Context
+	out         *output
+	lexing      lexing
+	diagnostics Diagnostics
 }
 
 // Macro represents a macro definition
@@ -24,6 +57,15 @@ type Macro struct {
 	Definition     string
 	IsOperator     bool
 	IsFunctionLike bool
+	
+	// pos and includeChain locate the ##: that defined the macro; pos is
+	// zero for macros defined through the API
+	pos          Position
+	includeChain []Position
+	used         bool
+	// predefined is set for ##i, which is expanded with the dynamic macros
+	// rather than matched in the text
+	predefined bool
 }
 
 // RandomGenerator provides pseudo-random numbers for ##$
@@ -40,6 +82,8 @@ func New() *Preprocessor {
 		lineNumber:  1,
 		braceCount:  0,
 		closeBraces: 0,
+		
+		disabledWarnings: make(map[Warning]bool),
 	}
 	
 	// Initialize predefined macros
@@ -48,70 +92,220 @@ func New() *Preprocessor {
 	return p
 }
 
-// Define sets a variable as defined
+// Define sets a variable as defined and, unless value is empty, also
+// defines an object-like macro with that value. DefineVariable and
+// DefineMacro say which of the two is meant.
 func (p *Preprocessor) Define(name string, value string) {
-	p.variables[name] = true
+	p.DefineVariable(name)
 	if value != "" {
-		p.macros[name] = &Macro{
-			Name:       name,
-			Definition: value,
-		}
+		p.DefineMacro(name, value)
 	}
 }
 
+// DefineVariable marks name as defined for ##~ and ##@ conditions without
+// creating a macro, so the name is left alone in the text
+func (p *Preprocessor) DefineVariable(name string) {
+	p.variables[name] = true
+}
+
+// DefineMacro defines an object-like macro that replaces name with value in
+// the text, even if value is empty. Like every macro, it also counts as
+// defined in conditions.
+func (p *Preprocessor) DefineMacro(name, value string) {
+	p.macros[name] = &Macro{
+		Name:       name,
+		Definition: value,
+	}
+	p.macroMatcher = nil
+}
+
+// SetEnvironment makes conditions treat a variable NAME as defined if
+// lookup finds prefix+NAME, for example with os.LookupEnv and the prefix
+// "OPP_" if OPP_DEBUG is set. Its value does not matter and is not a macro.
+// A nil lookup turns the environment off again.
+func (p *Preprocessor) SetEnvironment(prefix string, lookup func(name string) (string, bool)) {
+	p.envPrefix = prefix
+	p.lookupEnv = lookup
+}
+
 // Undefine removes a variable or macro
 func (p *Preprocessor) Undefine(name string) {
 	delete(p.variables, name)
 	delete(p.macros, name)
+	p.macroMatcher = nil
+}
+
+// SetKeepGoing enables or disables multi-diagnostic mode. When enabled,
+// Process records each error, recovers where it can and keeps going: a bad
+// condition counts as false, an unreadable include is skipped and an unknown
+// directive is ignored. The recorded diagnostics are returned as a
+// Diagnostics error together with the output produced.
+func (p *Preprocessor) SetKeepGoing(on bool) {
+	p.keepGoing = on
+}
+
+// SetPreserveLines enables or disables line-preserving output. When enabled,
+// every input line produces exactly one output line: directives, lines in
+// false conditional blocks and lines that expand to nothing become empty
+// lines, so output line N corresponds to input line N for a single file.
+func (p *Preprocessor) SetPreserveLines(on bool) {
+	p.preserveLines = on
+}
+
+// Diagnostics returns everything recorded by the last call to Process
+func (p *Preprocessor) Diagnostics() Diagnostics {
+	return p.diagnostics
 }
 
 // Process processes the input source code
 func (p *Preprocessor) Process(input string) (string, error) {
-	lines := strings.Split(input, "\n")
-	output := &strings.Builder{}
+	return p.processToString(context.Background(), strings.NewReader(input), nil)
+}
+
+// processToString collects the output of process in a string. In keep-going
+// mode the output is returned together with the diagnostics.
+func (p *Preprocessor) processToString(ctx context.Context, r io.Reader, sourceMap *SourceMap) (string, error) {
+	var result strings.Builder
+	err := p.process(ctx, r, &result, sourceMap)
+	if _, ok := err.(Diagnostics); err != nil && !ok {
+		return "", err
+	}
+	return result.String(), err
+}
+
+// process streams r through the preprocessor line by line, writing each
+// output line to w as soon as it is produced and filling in sourceMap if it
+// is not nil. It stops when ctx is done.
+func (p *Preprocessor) process(ctx context.Context, r io.Reader, w io.Writer, sourceMap *SourceMap) error {
+	p.diagnostics = nil
+	p.includeStack = nil
+	p.ctx = ctx
+	p.out = &output{w: w, sourceMap: sourceMap, maxBytes: p.limits.MaxOutputBytes}
+	defer func() { p.ctx, p.out = nil, nil }()
+	
+	if p.compat == CompatOriginal {
+		return p.processOriginal(r)
+	}
+	if err := p.processSource(r); err != nil {
+		return err
+	}
+	if err := p.warnUnusedMacros(); err != nil {
+		return err
+	}
+	if p.diagnostics.HasErrors() {
+		return p.diagnostics
+	}
+	return nil
+}
+
+// processSource runs the contents of a single file, p.currentFile, through
+// the preprocessor and emits the resulting lines. Included files are
+// processed recursively. Only the current line and the conditional stack
+// are kept in memory.
+func (p *Preprocessor) processSource(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	scanner.Split(scanLines)
 	
 	conditionalStack := &ConditionalStack{}
+	saved := p.startLexing()
+	defer func() { p.lexing = saved }()
 	
-	for i, line := range lines {
-		p.lineNumber = i + 1
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		p.lineNumber = lineNumber
+		line := scanner.Text()
+		emittedBefore := p.out.lines
+		
+		if err := p.checkContext(); err != nil {
+			return err
+		}
 		
 		processedLine, err := p.processLine(line, conditionalStack)
 		if err != nil {
-			return "", fmt.Errorf("line %d: %w", p.lineNumber, err)
+			return p.wrapError(DirectiveNone, -1, err)
 		}
 		
-		if processedLine != "" {
-			if output.Len() > 0 {
-				output.WriteString("\n")
+		// An include that spliced in content replaces its directive line
+		if len(processedLine.text) > 0 || (p.preserveLines && p.out.lines == emittedBefore) {
+			if err := p.emit(processedLine); err != nil {
+				return err
 			}
-			output.WriteString(processedLine)
 		}
 		
 		// Update brace counts after processing (for next line)
 		p.updateBraceCounts(line)
 	}
+	if err := scanner.Err(); err != nil {
+		p.lineNumber++
+		return p.errorf(DirectiveNone, -1, "cannot read input: %w", err)
+	}
 	
+	// Every file must close its own blocks
 	if !conditionalStack.IsEmpty() {
-		return "", fmt.Errorf("unclosed conditional block")
+		err := p.wrapError(DirectiveNone, -1, conditionalStack.unclosed())
+		if !p.tolerate(err) {
+			return err
+		}
 	}
 	
-	return output.String(), nil
+	return nil
+}
+
+// maxLineLength bounds the memory used for a single input line
+const maxLineLength = 64 * 1024 * 1024
+
+// scanLines is a bufio.SplitFunc that splits like strings.Split(input, "\n"):
+// carriage returns are kept and text after the last newline, even if empty,
+// is a final line
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, bufio.ErrFinalToken
+	}
+	return 0, nil, nil
 }
 
 // ProcessFile processes a file
 func (p *Preprocessor) ProcessFile(filename string) (string, error) {
-	content, err := os.ReadFile(filename)
+	f, err := p.openSourceFile(filename)
 	if err != nil {
-		return "", fmt.Errorf("cannot read file %s: %w", filename, err)
+		return "", err
+	}
+	defer f.Close()
+	return p.processToString(context.Background(), f, nil)
+}
+
+// ProcessFileWriter processes a file and streams the output to w
+func (p *Preprocessor) ProcessFileWriter(filename string, w io.Writer) error {
+	f, err := p.openSourceFile(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.process(context.Background(), f, w, nil)
+}
+
+// openSourceFile opens the top-level input file and makes it the current file
+func (p *Preprocessor) openSourceFile(filename string) (*os.File, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, &Error{
+			Pos: Position{File: filename},
+			Err: fmt.Errorf("cannot read file %s: %w", filename, err),
+		}
 	}
 	
 	p.currentFile = filename
-	return p.Process(string(content))
+	return f, nil
 }
 
 func (p *Preprocessor) initPredefinedMacros() {
-	// ##i - imaginary unit (requires complex.h)
-	p.macros["##i"] = &Macro{Name: "##i", Definition: "1i"}
+	// ##i - imaginary unit of the target language. Like a macro it counts as
+	// defined in conditions and can be undefined with ##-.
+	p.macros["##i"] = &Macro{Name: "##i", predefined: true}
+	p.macroMatcher = nil
 	
 	// ##_, ##$, ##{, ##} are handled dynamically in expansion
 }
@@ -1,6 +1,7 @@
 package opp
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -48,6 +49,42 @@ is debug
 			defines:  map[string]bool{"DEBUG": true},
 			expected: "is debug",
 		},
+		{
+			name:     "else-if chain takes only the first true branch",
+			input:    `##~(~A)
+a
+##@~(~B)
+b
+##@
+neither
+##.`,
+			defines:  map[string]bool{"A": true, "B": true},
+			expected: "a",
+		},
+		{
+			name:     "else-if chain",
+			input:    `##~(~A)
+a
+##@~(~B)
+b
+##@
+neither
+##.`,
+			defines:  map[string]bool{"B": true},
+			expected: "b",
+		},
+		{
+			name:     "else after else-if chain",
+			input:    `##~(~A)
+a
+##@~(~B)
+b
+##@
+neither
+##.`,
+			defines:  map[string]bool{},
+			expected: "neither",
+		},
 	}
 	
 	for _, tt := range tests {
@@ -189,4 +226,23 @@ func TestRandomNumber(t *testing.T) {
 	if lines[0] == lines[1] {
 		t.Errorf("Random numbers should differ: %s == %s", lines[0], lines[1])
 	}
+}
+
+func TestElseAfterFinalElse(t *testing.T) {
+	p := New()
+	_, err := p.Process("##~A|~A\na\n##@\nb\n##@~B\nc\n##.")
+	if err == nil || !strings.Contains(err.Error(), "5:1: ##@ after the final ##@ of the block") {
+		t.Errorf("Process() error = %v, want ##@ after the final ##@", err)
+	}
+	
+	p = New()
+	p.SetKeepGoing(true)
+	result, err := p.Process("##~A|~A\na\n##@\nb\n##@\nc\n##.\nd")
+	if result != "a\nd" {
+		t.Errorf("Process() = %q, want %q", result, "a\nd")
+	}
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 1 || diagnostics[0].Directive != DirectiveElse {
+		t.Errorf("Process() error = %v, want one ##@ diagnostic", err)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,129 @@
+package opp
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// LineDirectiveStyle selects the target-language line directives emitted
+// when the source position of the output changes
+type LineDirectiveStyle int
+
+const (
+	LineDirectivesNone LineDirectiveStyle = iota
+	LineDirectivesGo                      // //line file:N
+	LineDirectivesC                       // #line N "file"
+)
+
+var lineDirectiveNames = map[LineDirectiveStyle]string{
+	LineDirectivesNone: "none",
+	LineDirectivesGo:   "go",
+	LineDirectivesC:    "c",
+}
+
+func (s LineDirectiveStyle) String() string {
+	if name, ok := lineDirectiveNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("LineDirectiveStyle(%d)", int(s))
+}
+
+// ParseLineDirectiveStyle returns the style with the given name: none, go or c
+func ParseLineDirectiveStyle(name string) (LineDirectiveStyle, error) {
+	for style, n := range lineDirectiveNames {
+		if n == name {
+			return style, nil
+		}
+	}
+	return LineDirectivesNone, fmt.Errorf("unknown line directive style %q", name)
+}
+
+// SetLineDirectives makes Process emit line directives in the given style
+// before the first output line and whenever the source position of the next
+// output line is not the line after the previous one: after includes,
+// skipped conditional blocks and directives. Compilers then report positions
+// in the original OPP sources.
+func (p *Preprocessor) SetLineDirectives(style LineDirectiveStyle) {
+	p.lineDirectives = style
+}
+
+// output writes processed lines
+type output struct {
+	w     io.Writer
+	lines int
+	bytes int64
+	// maxBytes bounds bytes when positive
+	maxBytes int64
+	// last is the source position of the most recent emitted line
+	last Position
+	// sourceMap receives the segments of every line when not nil
+	sourceMap *SourceMap
+}
+
+// emit writes one processed line originating from the current source line
+func (p *Preprocessor) emit(line *mappedLine) error {
+	pos := Position{File: p.currentFile, Line: p.lineNumber}
+	if p.lineDirectives != LineDirectivesNone {
+		if p.out.lines == 0 || pos.File != p.out.last.File || pos.Line != p.out.last.Line+1 {
+			if err := p.out.writeLine(lineDirective(p.lineDirectives, pos), nil); err != nil {
+				return p.wrapError(DirectiveNone, -1, err)
+			}
+		}
+	}
+	var segments []Segment
+	if p.out.sourceMap != nil {
+		segments = line.segments(pos.File, pos.Line)
+	}
+	p.out.last = pos
+	if err := p.out.writeLine(line.String(), segments); err != nil {
+		return p.wrapError(DirectiveNone, -1, err)
+	}
+	return nil
+}
+
+// writeLine writes text, separated from the previous line by a newline
+func (o *output) writeLine(text string, segments []Segment) error {
+	if o.lines > 0 {
+		text = "\n" + text
+	}
+	o.bytes += int64(len(text))
+	if o.maxBytes > 0 && o.bytes > o.maxBytes {
+		return &LimitError{Limit: LimitOutputBytes, Max: o.maxBytes}
+	}
+	o.lines++
+	if o.sourceMap != nil {
+		o.sourceMap.Lines = append(o.sourceMap.Lines, segments)
+	}
+	_, err := io.WriteString(o.w, text)
+	return err
+}
+
+// write writes text as it is, for output that does its own line endings
+func (o *output) write(text string) error {
+	o.bytes += int64(len(text))
+	if o.maxBytes > 0 && o.bytes > o.maxBytes {
+		return &LimitError{Limit: LimitOutputBytes, Max: o.maxBytes}
+	}
+	_, err := io.WriteString(o.w, text)
+	return err
+}
+
+// lineDirective formats a directive saying that the next line is pos
+func lineDirective(style LineDirectiveStyle, pos Position) string {
+	switch style {
+	case LineDirectivesGo:
+		if pos.File == "" {
+			// The :line:col form keeps the current file name
+			return fmt.Sprintf("//line :%d:1", pos.Line)
+		}
+		return fmt.Sprintf("//line %s:%d", pos.File, pos.Line)
+	case LineDirectivesC:
+		if pos.File == "" {
+			return fmt.Sprintf("#line %d", pos.Line)
+		}
+		return fmt.Sprintf("#line %d %s", pos.Line, strconv.Quote(pos.File))
+	default:
+		return ""
+	}
+}
\ No newline at end of file
@@ -3,17 +3,26 @@ package opp
 import (
 	"fmt"
 	"strings"
+	"unicode"
 )
 
-// ConditionalStack manages nested conditional compilation
+// ConditionalStack manages nested conditional compilation. Each level is an
+// if/else-if/else chain: ##~ opens it, ##@ with a condition is an else-if
+// and a bare ##@ the final else.
 type ConditionalStack struct {
 	conditions []bool
-	inElse     []bool
+	// taken records whether some branch of the level was taken
+	taken  []bool
+	inElse []bool
+	// chains holds the positions of the ##~ and ##@ of each level
+	chains [][]Position
 }
 
 func (c *ConditionalStack) Push(condition bool) {
 	c.conditions = append(c.conditions, condition)
+	c.taken = append(c.taken, condition)
 	c.inElse = append(c.inElse, false)
+	c.chains = append(c.chains, nil)
 }
 
 func (c *ConditionalStack) Pop() error {
@@ -21,21 +30,96 @@ func (c *ConditionalStack) Pop() error {
 		return fmt.Errorf("no matching conditional to close")
 	}
 	c.conditions = c.conditions[:len(c.conditions)-1]
+	c.taken = c.taken[:len(c.taken)-1]
 	c.inElse = c.inElse[:len(c.inElse)-1]
+	c.chains = c.chains[:len(c.chains)-1]
 	return nil
 }
 
-func (c *ConditionalStack) ToggleElse() error {
+// Else starts the final branch of the innermost level, taken if no earlier
+// branch was
+func (c *ConditionalStack) Else() error {
+	return c.startBranch(true, true)
+}
+
+// ElseIf starts a branch of the innermost level that is taken if condition
+// holds and no earlier branch was taken
+func (c *ConditionalStack) ElseIf(condition bool) error {
+	return c.startBranch(condition, false)
+}
+
+// startBranch handles ##@; nothing may follow the final, bare ##@
+func (c *ConditionalStack) startBranch(condition, final bool) error {
 	if len(c.conditions) == 0 {
 		return fmt.Errorf("##@ without matching conditional")
 	}
-	idx := len(c.inElse) - 1
-	c.inElse[idx] = !c.inElse[idx]
-	// Flip the condition when entering else
-	c.conditions[idx] = !c.conditions[idx]
+	idx := len(c.conditions) - 1
+	if c.inElse[idx] {
+		// Skip everything up to the ##.
+		c.conditions[idx] = false
+		return fmt.Errorf("##@ after the final ##@ of the block")
+	}
+	c.conditions[idx] = condition && !c.taken[idx]
+	c.taken[idx] = c.taken[idx] || condition
+	c.inElse[idx] = final
 	return nil
 }
 
+// record adds the position of a ##~ or ##@ to the innermost level
+func (c *ConditionalStack) record(pos Position) {
+	if len(c.chains) > 0 {
+		idx := len(c.chains) - 1
+		c.chains[idx] = append(c.chains[idx], pos)
+	}
+}
+
+// unclosed returns the error for the levels still open
+func (c *ConditionalStack) unclosed() *UnclosedError {
+	blocks := make([][]Position, len(c.chains))
+	for i, chain := range c.chains {
+		blocks[i] = append([]Position(nil), chain...)
+	}
+	return &UnclosedError{Blocks: blocks}
+}
+
+// UnclosedError reports conditional blocks still open at the end of a file
+type UnclosedError struct {
+	// Blocks holds the open blocks, outermost first. Each lists the
+	// position of its ##~ followed by those of its ##@ directives.
+	Blocks [][]Position
+}
+
+func (e *UnclosedError) Error() string {
+	msg := "unclosed conditional block"
+	if len(e.Blocks) > 1 {
+		msg += "s"
+	}
+	for i, chain := range e.Blocks {
+		if i == 0 {
+			msg += ": "
+		} else {
+			msg += "; "
+		}
+		for j, pos := range chain {
+			directive := "##@"
+			if j == 0 {
+				directive = "##~"
+			} else {
+				msg += ", "
+			}
+			msg += directive + " at " + pos.String()
+		}
+	}
+	return msg
+}
+
+// ToggleElse is the bare ##@.
+//
+// Deprecated: use Else.
+func (c *ConditionalStack) ToggleElse() error {
+	return c.Else()
+}
+
 func (c *ConditionalStack) ShouldProcess() bool {
 	for _, cond := range c.conditions {
 		if !cond {
@@ -49,59 +133,79 @@ func (c *ConditionalStack) IsEmpty() bool {
 	return len(c.conditions) == 0
 }
 
-func (p *Preprocessor) processLine(line string, stack *ConditionalStack) (string, error) {
+func (p *Preprocessor) processLine(line string, stack *ConditionalStack) (*mappedLine, error) {
 	trimmed := strings.TrimSpace(line)
 	
 	// Check for OPP directives
 	if strings.HasPrefix(trimmed, "##") {
-		return p.processDirective(trimmed, stack)
+		offset := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+		result, err := p.processDirective(trimmed, offset, stack)
+		if err != nil {
+			return nil, err
+		}
+		// Only standalone predefined macros produce output
+		return expansionLine(result, offset, trimmed), nil
 	}
 	
 	// If we're in a false conditional block, skip the line
 	if !stack.ShouldProcess() {
-		return "", nil
+		return &mappedLine{}, nil
 	}
 	
 	// Process macros in the line
 	return p.expandMacros(line)
 }
 
-func (p *Preprocessor) processDirective(line string, stack *ConditionalStack) (string, error) {
+// processDirective handles a ## line; offset is the byte offset of the ##
+// within the original line and is used for error positions
+func (p *Preprocessor) processDirective(line string, offset int, stack *ConditionalStack) (string, error) {
 	// Remove ## prefix
 	directive := line[2:]
 	
 	switch {
 	case directive == ".":
 		// End conditional block
-		return "", stack.Pop()
+		if err := stack.Pop(); err != nil {
+			return "", p.tolerateOrFail(p.wrapError(DirectiveEnd, offset, err))
+		}
+		return "", nil
 		
 	case strings.HasPrefix(directive, "@"):
-		// Else-like behavior - the rest is a new condition
-		err := stack.ToggleElse()
-		if err != nil {
-			return "", err
+		// Else without a condition, else-if with one
+		if len(directive) == 1 {
+			if err := stack.Else(); err != nil {
+				return "", p.tolerateOrFail(p.wrapError(DirectiveElse, offset, err))
+			}
+			stack.record(p.position(offset))
+			return "", nil
 		}
-		// If there's a condition after @, evaluate it
-		if len(directive) > 1 {
-			condition, err := p.evaluateCondition(directive[1:])
-			if err != nil {
+		condition, err := p.evaluateConditionAt(directive[1:], offset+3)
+		if err != nil {
+			err.Directive = DirectiveElse
+			if !p.tolerate(err) {
 				return "", err
 			}
-			// Replace the toggled condition with the new one
-			if len(stack.conditions) > 0 {
-				stack.conditions[len(stack.conditions)-1] = condition
-			}
+			// A bad condition counts as false
+			condition = false
+		}
+		if err := stack.ElseIf(condition); err != nil {
+			return "", p.tolerateOrFail(p.wrapError(DirectiveElse, offset, err))
 		}
+		stack.record(p.position(offset))
 		return "", nil
 		
 	case strings.HasPrefix(directive, "~"):
 		// Conditional compilation
-		var condition bool
-		condition, err := p.evaluateCondition(directive)
+		condition, err := p.evaluateConditionAt(directive, offset+2)
 		if err != nil {
-			return "", err
+			if !p.tolerate(err) {
+				return "", err
+			}
+			// A bad condition counts as false
+			condition = false
 		}
 		stack.Push(condition)
+		stack.record(p.position(offset))
 		return "", nil
 		
 	case strings.HasPrefix(directive, "<"):
@@ -109,93 +213,68 @@ func (p *Preprocessor) processDirective(line string, stack *ConditionalStack) (s
 		if !stack.ShouldProcess() {
 			return "", nil
 		}
-		return p.processInclude(directive)
+		return p.processInclude(directive, offset)
 		
 	case strings.HasPrefix(directive, ":"):
 		// Define macro
 		if !stack.ShouldProcess() {
 			return "", nil
 		}
-		return "", p.defineMacro(directive[1:])
+		return "", p.tolerateOrFail(p.defineMacro(directive[1:], offset+3))
 		
 	case strings.HasPrefix(directive, "-"):
 		// Undefine macro
 		if !stack.ShouldProcess() {
 			return "", nil
 		}
-		p.Undefine(strings.TrimSpace(directive[1:]))
-		return "", nil
+		return "", p.undefineMacro(strings.TrimSpace(directive[1:]), offset)
 		
 	default:
 		// Check for predefined macros
 		if !stack.ShouldProcess() {
 			return "", nil
 		}
-		return p.expandPredefinedMacro(line)
+		result, err := p.expandPredefinedMacro(line, offset)
+		if err != nil {
+			// Unknown directives are dropped in keep-going mode
+			return "", p.tolerateOrFail(err)
+		}
+		return result, nil
 	}
 }
 
 func (p *Preprocessor) evaluateCondition(expr string) (bool, error) {
-	// Handle parentheses at the expression level
-	expr = strings.TrimSpace(expr)
-	
-	// Find the rightmost | that's not inside parentheses
-	parenDepth := 0
-	splitPos := -1
-	
-	for i := len(expr) - 1; i >= 0; i-- {
-		switch expr[i] {
-		case ')':
-			parenDepth++
-		case '(':
-			parenDepth--
-		case '|':
-			if parenDepth == 0 {
-				splitPos = i
-				break
-			}
-		}
-	}
-	
-	if splitPos == -1 {
-		// No | found, this should be a single term
-		return p.evaluateTerm(expr)
-	}
-	
-	// Split at the |
-	left := expr[:splitPos]
-	right := expr[splitPos+1:]
-	
-	// Evaluate each part
-	leftVal, err := p.evaluateTerm(strings.TrimSpace(left))
+	result, err := p.evaluateConditionAt(expr, 0)
 	if err != nil {
 		return false, err
 	}
-	
-	rightVal, err := p.evaluateTerm(strings.TrimSpace(right))
+	return result, nil
+}
+
+// evaluateConditionAt evaluates expr, which starts at byte offset offset of
+// the current line, so that errors can point at the offending term
+func (p *Preprocessor) evaluateConditionAt(expr string, offset int) (bool, *Error) {
+	cond, err := ParseCondition(expr)
 	if err != nil {
-		return false, err
+		return false, p.wrapError(DirectiveCondition, offset+err.(*SyntaxError).Offset, err)
 	}
-	
-	// NAND logic: ~a | ~b
-	return !leftVal || !rightVal, nil
+	return cond.Eval(p.isDefined), nil
 }
 
-func (p *Preprocessor) evaluateTerm(term string) (bool, error) {
-	// Remove ~ prefix
-	if !strings.HasPrefix(term, "~") {
-		return false, fmt.Errorf("term must start with ~: %s", term)
+// isDefined reports whether name is a defined variable or macro, or is
+// found in the environment
+func (p *Preprocessor) isDefined(name string) bool {
+	_, varDefined := p.variables[name]
+	macro, macroDefined := p.macros[name]
+	if macroDefined {
+		macro.used = true
 	}
-	term = term[1:]
-	
-	// Handle parentheses
-	if strings.HasPrefix(term, "(") && strings.HasSuffix(term, ")") {
-		inner := term[1 : len(term)-1]
-		return p.evaluateCondition(inner)
+	if varDefined || macroDefined {
+		return true
 	}
-	
-	// Check if variable or macro is defined
-	_, varDefined := p.variables[term]
-	_, macroDefined := p.macros[term]
-	return varDefined || macroDefined, nil
+	if p.lookupEnv != nil {
+		_, envDefined := p.lookupEnv(p.envPrefix + name)
+		return envDefined
+	}
+	return false
 }
\ No newline at end of file
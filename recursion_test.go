@@ -0,0 +1,76 @@
+package opp
+
+import "testing"
+
+func TestRecursionSuppression(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "self reference",
+			input:    "##:A A A\nA",
+			expected: "A A",
+		},
+		{
+			name:     "identity",
+			input:    "##:printf printf\nprintf(\"%d\", x);",
+			expected: "printf(\"%d\", x);",
+		},
+		{
+			name:     "wrapper",
+			input:    "##:malloc tracked_malloc\np = malloc(10);",
+			expected: "p = tracked_malloc(10);",
+		},
+		{
+			name:     "function-like wrapper",
+			input:    "##:malloc tracked_malloc(#0, ##_)\np = malloc(10);",
+			expected: "p = tracked_malloc(10, -3);",
+		},
+		{
+			name:     "mutual recursion",
+			input:    "##:A B\n##:B A\nA B",
+			expected: "A B",
+		},
+		{
+			name:     "indirect recursion",
+			input:    "##:A [B]\n##:B (C)\n##:C A\nA",
+			expected: "[(A)]",
+		},
+		{
+			name:     "nested calls in arguments",
+			input:    "##:f [#0]\nf(f(1))",
+			expected: "[[1]]",
+		},
+		{
+			name:     "recursive call in argument",
+			input:    "##:malloc tracked_malloc(#0)\nmalloc(malloc(1))",
+			expected: "tracked_malloc(tracked_malloc(1))",
+		},
+		{
+			name:     "expansion completes a call",
+			input:    "##:f [#0]\n##:g f\ng(1)",
+			expected: "[1]",
+		},
+		{
+			name:     "same macro again after expansion",
+			input:    "##:A B\n##:B A\nA A",
+			expected: "A A",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.SetLimits(Limits{MaxExpansions: 100})
+			result, err := p.Process(tt.input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
\ No newline at end of file
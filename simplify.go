@@ -0,0 +1,335 @@
+package opp
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Const is a constant expression, the result of simplifying tautologies
+// and contradictions
+type Const bool
+
+func (c Const) Eval(func(string) bool) bool { return bool(c) }
+
+func (c Const) String() string {
+	if c {
+		return "true"
+	}
+	return "false"
+}
+
+// maxMinimizeVariables bounds the truth tables built by Simplify
+const maxMinimizeVariables = 10
+
+// Simplify returns a short expression equivalent to x. Negations are pushed
+// down to the variables and, for expressions over a handful of variables,
+// the result is the shortest of that, the minimal sum of products and the
+// minimal product of sums. Tautologies and contradictions become Const.
+func Simplify(x Expr) Expr {
+	best := normalize(x, false)
+	names := Variables(best)
+	if len(names) > maxMinimizeVariables {
+		return best
+	}
+	
+	table := truthTable(best, names)
+	sop := minimize(table, names, false)
+	pos := minimize(complement(table), names, true)
+	for _, candidate := range []Expr{sop, pos} {
+		if len(candidate.String()) < len(best.String()) {
+			best = candidate
+		}
+	}
+	if _, ok := sop.(Const); ok {
+		best = sop
+	}
+	return best
+}
+
+// Variables returns the variables of x in order of first appearance
+func Variables(x Expr) []string {
+	var names []string
+	seen := map[string]bool{}
+	var walk func(Expr)
+	walk = func(x Expr) {
+		switch x := x.(type) {
+		case Var:
+			if !seen[x.Name] {
+				seen[x.Name] = true
+				names = append(names, x.Name)
+			}
+		case Not:
+			walk(x.X)
+		case And:
+			for _, y := range x {
+				walk(y)
+			}
+		case Or:
+			for _, y := range x {
+				walk(y)
+			}
+		}
+	}
+	walk(x)
+	return names
+}
+
+// normalize returns x, or !x if negated, in negation normal form with
+// nested operators flattened, repeated operands dropped and constants
+// folded
+func normalize(x Expr, negated bool) Expr {
+	switch x := x.(type) {
+	case Not:
+		return normalize(x.X, !negated)
+	case And:
+		if negated {
+			return joinNormalized(x, true, false)
+		}
+		return joinNormalized(x, false, true)
+	case Or:
+		if negated {
+			return joinNormalized(x, true, true)
+		}
+		return joinNormalized(x, false, false)
+	case Const:
+		return Const(bool(x) != negated)
+	default:
+		if negated {
+			return Not{x}
+		}
+		return x
+	}
+}
+
+// joinNormalized normalizes the operands and joins them with && if and is
+// set, otherwise with ||
+func joinNormalized(operands []Expr, negated, and bool) Expr {
+	// The value that decides the result on its own, false for &&
+	absorbing := Const(!and)
+	
+	var result []Expr
+	seen := map[string]bool{}
+	var add func(x Expr) bool
+	add = func(x Expr) bool {
+		switch x := x.(type) {
+		case Const:
+			return x != absorbing
+		case And:
+			if and {
+				for _, y := range x {
+					if !add(y) {
+						return false
+					}
+				}
+				return true
+			}
+		case Or:
+			if !and {
+				for _, y := range x {
+					if !add(y) {
+						return false
+					}
+				}
+				return true
+			}
+		}
+		// x together with !x decides the result
+		if seen[negate(x).String()] {
+			return false
+		}
+		if key := x.String(); !seen[key] {
+			seen[key] = true
+			result = append(result, x)
+		}
+		return true
+	}
+	
+	for _, x := range operands {
+		if !add(normalize(x, negated)) {
+			return absorbing
+		}
+	}
+	switch len(result) {
+	case 0:
+		return !absorbing
+	case 1:
+		return result[0]
+	}
+	if and {
+		return And(result)
+	}
+	return Or(result)
+}
+
+// truthTable evaluates x for every assignment of names; bit i of an
+// assignment is names[i]
+func truthTable(x Expr, names []string) []bool {
+	index := map[string]int{}
+	for i, name := range names {
+		index[name] = i
+	}
+	table := make([]bool, 1<<len(names))
+	for assignment := range table {
+		table[assignment] = x.Eval(func(name string) bool {
+			return assignment&(1<<index[name]) != 0
+		})
+	}
+	return table
+}
+
+func complement(table []bool) []bool {
+	result := make([]bool, len(table))
+	for i, v := range table {
+		result[i] = !v
+	}
+	return result
+}
+
+// implicant is a product of literals: the variables not in mask must have
+// the values in value
+type implicant struct {
+	value, mask int
+}
+
+func (m implicant) covers(assignment int) bool {
+	return assignment&^m.mask == m.value
+}
+
+// minimize returns the minimal sum of products for table, or with
+// negated, the product of sums that is the negation of that
+func minimize(table []bool, names []string, negated bool) Expr {
+	var terms []implicant
+	for assignment, v := range table {
+		if v {
+			terms = append(terms, implicant{value: assignment})
+		}
+	}
+	if len(terms) == 0 {
+		return Const(negated)
+	}
+	if len(terms) == len(table) {
+		return Const(!negated)
+	}
+	
+	cover := coverImplicants(primeImplicants(terms), terms)
+	products := make([]Expr, len(cover))
+	for i, m := range cover {
+		var literals []Expr
+		for bit, name := range names {
+			if m.mask&(1<<bit) != 0 {
+				continue
+			}
+			var literal Expr = Var{name}
+			if (m.value&(1<<bit) != 0) == negated {
+				literal = Not{literal}
+			}
+			literals = append(literals, literal)
+		}
+		products[i] = joinOperands(literals, !negated)
+	}
+	return joinOperands(products, negated)
+}
+
+// joinOperands joins operands with && if and is set, otherwise with ||
+func joinOperands(operands []Expr, and bool) Expr {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	if and {
+		return And(operands)
+	}
+	return Or(operands)
+}
+
+// primeImplicants combines minterms with the Quine-McCluskey method
+func primeImplicants(minterms []implicant) []implicant {
+	var primes []implicant
+	current := minterms
+	for len(current) > 0 {
+		combined := map[implicant]bool{}
+		used := make([]bool, len(current))
+		for i := range current {
+			for j := i + 1; j < len(current); j++ {
+				a, b := current[i], current[j]
+				diff := a.value ^ b.value
+				if a.mask != b.mask || bits.OnesCount(uint(diff)) != 1 {
+					continue
+				}
+				combined[implicant{value: a.value &^ diff, mask: a.mask | diff}] = true
+				used[i], used[j] = true, true
+			}
+		}
+		for i, m := range current {
+			if !used[i] {
+				primes = append(primes, m)
+			}
+		}
+		
+		current = current[:0:0]
+		for m := range combined {
+			current = append(current, m)
+		}
+		sortImplicants(current)
+	}
+	return primes
+}
+
+// coverImplicants picks the essential primes, then greedily the ones
+// covering the most remaining minterms
+func coverImplicants(primes, minterms []implicant) []implicant {
+	var cover []implicant
+	remaining := map[int]bool{}
+	for _, m := range minterms {
+		remaining[m.value] = true
+	}
+	take := func(p implicant) {
+		cover = append(cover, p)
+		for v := range remaining {
+			if p.covers(v) {
+				delete(remaining, v)
+			}
+		}
+	}
+	
+	for _, m := range minterms {
+		var only []implicant
+		for _, p := range primes {
+			if p.covers(m.value) {
+				only = append(only, p)
+			}
+		}
+		if len(only) == 1 && remaining[m.value] {
+			take(only[0])
+		}
+	}
+	for len(remaining) > 0 {
+		best, bestCount := implicant{}, -1
+		for _, p := range primes {
+			count := 0
+			for v := range remaining {
+				if p.covers(v) {
+					count++
+				}
+			}
+			// Prefer fewer literals, then a stable order
+			if count > bestCount || count == bestCount && bits.OnesCount(uint(p.mask)) > bits.OnesCount(uint(best.mask)) {
+				best, bestCount = p, count
+			}
+		}
+		take(best)
+	}
+	
+	sortImplicants(cover)
+	return cover
+}
+
+// sortImplicants orders implicants deterministically, most general first
+func sortImplicants(list []implicant) {
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.mask != b.mask {
+			return bits.OnesCount(uint(a.mask)) > bits.OnesCount(uint(b.mask)) || bits.OnesCount(uint(a.mask)) == bits.OnesCount(uint(b.mask)) && a.mask > b.mask
+		}
+		return a.value < b.value
+	})
+}
\ No newline at end of file
@@ -0,0 +1,72 @@
+package opp
+
+import "testing"
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		condition string
+		expected  string
+	}{
+		{"~DEBUG", "DEBUG"},
+		{"~DEBUG|~DEBUG", "!DEBUG"},
+		{"~(~DEBUG|~DEBUG)|~(~DEBUG|~DEBUG)", "DEBUG"},
+		{"~(~X|~X)|~(~Y|~Y)", "X || Y"},
+		{"~(~(~X|~X)|~(~Y|~Y))", "X || Y"},
+		{"~(~X|~Y)", "!X || !Y"},
+		{"~X|~Y|~Z", "!X || !Y || !Z"},
+		{"~A|~(~A|~A)", "true"},
+		{"~(~(~A|~(~A|~A))|~(~A|~(~A|~A)))", "false"},
+		{"~(~A|~(~A|~A))", "true"},
+		{"~(~A|~B)|~(~A|~(~B|~B))", "A"},
+		{"~(~(~A|~B)|~(~A|~C))", "A && (B || C)"},
+		{"~(~A|~(~(~B)|~(~C)))", "!A || B && C"},
+		{"~(~A|~B)|~(~(~A|~A)|~C)", "A && B || !A && C"},
+	}
+
+	for _, tt := range tests {
+		cond, err := ParseCondition(tt.condition)
+		if err != nil {
+			t.Fatalf("ParseCondition(%q) error = %v", tt.condition, err)
+		}
+		x := cond.Expr()
+		simplified := Simplify(x)
+		if got := simplified.String(); got != tt.expected {
+			t.Errorf("Simplify(%s) = %s, want %s", tt.condition, got, tt.expected)
+		}
+
+		// The simplified form must be equivalent
+		names := Variables(x)
+		for bits := 0; bits < 1<<len(names); bits++ {
+			defined := func(name string) bool {
+				for i, n := range names {
+					if n == name {
+						return bits&(1<<i) != 0
+					}
+				}
+				return false
+			}
+			if simplified.Eval(defined) != cond.Eval(defined) {
+				t.Errorf("Simplify(%s) = %s differs for assignment %b", tt.condition, simplified, bits)
+			}
+		}
+	}
+}
+
+func TestCompileConstants(t *testing.T) {
+	p := New()
+	for _, c := range []Const{true, false} {
+		cond := CompileExpr(c).String()
+		for _, define := range []bool{false, true} {
+			if define {
+				p.Define("_", "")
+			}
+			got, err := p.evaluateCondition(cond)
+			if err != nil {
+				t.Fatalf("evaluateCondition(%q) error = %v", cond, err)
+			}
+			if got != bool(c) {
+				t.Errorf("%s compiled to %s evaluates to %v", c, cond, got)
+			}
+		}
+	}
+}
\ No newline at end of file
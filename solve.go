@@ -0,0 +1,113 @@
+package opp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Solution says which variables make a line appear in the output
+type Solution struct {
+	// Pos is the position of the line
+	Pos Position
+	// Condition is the combined condition of the blocks around the line,
+	// simplified
+	Condition Expr
+	// Satisfiable is false if no combination of variables makes the line
+	// appear
+	Satisfiable bool
+	// Defined and Undefined list the variables to define and to leave
+	// undefined, in order of first appearance. Variables in neither list do
+	// not matter.
+	Defined, Undefined []string
+}
+
+// Solve finds variables that make line of r, which is read from filename,
+// appear in the output. It follows the ##~, ##@ and ##. directives before
+// the line; for a directive line, the blocks open before it count. The
+// solution prefers leaving variables undefined. Macros defined
+// with ##: in the file itself are not taken into account. A syntax error in
+// a condition the line depends on is returned as Diagnostics.
+func Solve(r io.Reader, filename string, line int) (*Solution, error) {
+	var solution *Solution
+	w := newBlockWalker()
+	var diagnostics Diagnostics
+	lineNumber := 0
+	err := scanConditions(r, filename, func(text string, d *conditionDirective) error {
+		lineNumber++
+		if lineNumber == line {
+			if solution = w.solve(Position{File: filename, Line: line, Column: 1}); solution != nil {
+				return errSolveDone
+			}
+			// Read on to collect the syntax errors
+		}
+		if d != nil {
+			if err := w.apply(d); err != nil {
+				diagnostics = append(diagnostics, &Diagnostic{
+					Severity: SeverityError,
+					Error:    &Error{Pos: d.Pos, Directive: d.Directive, Err: err},
+				})
+			}
+		}
+		return nil
+	})
+	
+	switch {
+	case err == errSolveDone:
+		return solution, nil
+	case lineNumber < line:
+		return nil, &Error{Pos: Position{File: filename}, Err: fmt.Errorf("line %d is past the end of the file", line)}
+	}
+	if syntaxErrors, ok := err.(Diagnostics); ok {
+		diagnostics = append(diagnostics, syntaxErrors...)
+		sortDiagnostics(diagnostics)
+		return nil, diagnostics
+	}
+	return nil, err
+}
+
+// SolveFile works like Solve for the named file
+func SolveFile(filename string, line int) (*Solution, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, &Error{Pos: Position{File: filename}, Err: err}
+	}
+	defer f.Close()
+	return Solve(f, filename, line)
+}
+
+// errSolveDone stops scanConditions once Solve has reached the line
+var errSolveDone = errors.New("solved")
+
+// solve returns the solution for the current position, or nil if it
+// depends on an invalid condition
+func (w *blockWalker) solve(pos Position) *Solution {
+	reached, ok := w.reached()
+	if !ok {
+		return nil
+	}
+	solution := &Solution{Pos: pos, Condition: w.reachedExpr()}
+	assignment, ok := w.bdd.satisfy(reached)
+	if !ok {
+		return solution
+	}
+	solution.Satisfiable = true
+	
+	names := make([]string, 0, len(assignment))
+	for name := range assignment {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return w.bdd.vars[names[i]] < w.bdd.vars[names[j]]
+	})
+	for _, name := range names {
+		if assignment[name] {
+			solution.Defined = append(solution.Defined, name)
+		} else {
+			solution.Undefined = append(solution.Undefined, name)
+		}
+	}
+	return solution
+}
\ No newline at end of file
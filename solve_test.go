@@ -0,0 +1,102 @@
+package opp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSolve(t *testing.T) {
+	input := strings.Join([]string{
+		"top", // 1
+		"##~(~(~A|~A)|~(~B|~B))|~(~(~A|~A)|~(~B|~B))", // 2: !A && !B
+		"neither",       // 3
+		"##~(~C|~C)|~D", // 4: C || !D
+		"nested",        // 5
+		"##@",           // 6
+		"c and d",       // 7
+		"##.",           // 8
+		"##@",           // 9
+		"a or b",        // 10
+		"##~A",          // 11: A
+		"a",             // 12
+		"##@~A|~A",      // 13: !A
+		"b only",        // 14
+		"##~B",          // 15: B
+		"##@",           // 16
+		"never",         // 17
+		"##.",           // 18
+		"##.",           // 19
+		"##.",           // 20
+	}, "\n")
+
+	tests := []struct {
+		line      int
+		condition string
+		defined   string
+		undefined string
+	}{
+		{1, "true", "", ""},
+		{3, "!A && !B", "", "A B"},
+		{5, "!A && !B && (C || !D)", "", "A B C D"},
+		{7, "!A && !B && !C && D", "D", "A B C"},
+		{12, "A", "A", ""},
+		{14, "!A && B", "B", "A"},
+		{17, "false", "", ""},
+	}
+	for _, tt := range tests {
+		solution, err := Solve(strings.NewReader(input), "main.opp", tt.line)
+		if err != nil {
+			t.Errorf("Solve(%d) error = %v", tt.line, err)
+			continue
+		}
+		if got := solution.Condition.String(); got != tt.condition {
+			t.Errorf("Solve(%d).Condition = %s, want %s", tt.line, got, tt.condition)
+		}
+		if solution.Satisfiable != (tt.condition != "false") {
+			t.Errorf("Solve(%d).Satisfiable = %v", tt.line, solution.Satisfiable)
+		}
+		if got := strings.Join(solution.Defined, " "); got != tt.defined {
+			t.Errorf("Solve(%d).Defined = %q, want %q", tt.line, got, tt.defined)
+		}
+		if got := strings.Join(solution.Undefined, " "); got != tt.undefined {
+			t.Errorf("Solve(%d).Undefined = %q, want %q", tt.line, got, tt.undefined)
+		}
+	}
+}
+
+func TestSolveAgreesWithProcess(t *testing.T) {
+	input := "##~(~A|~A)|~(~(~B|~B)|~C)\n##~B\nhere\n##.\n##."
+	solution, err := Solve(strings.NewReader(input), "main.opp", 3)
+	if err != nil || !solution.Satisfiable {
+		t.Fatalf("Solve() = %v, %v", solution, err)
+	}
+	p := New()
+	for _, name := range solution.Defined {
+		p.Define(name, "")
+	}
+	result, err := p.Process(input)
+	if err != nil || result != "here" {
+		t.Errorf("Process() with %v = %q, %v, want here", solution.Defined, result, err)
+	}
+}
+
+func TestSolveErrors(t *testing.T) {
+	input := "##~(~a\nx\n##.\n##~b|~b\ny\n##.\n##."
+	if _, err := Solve(strings.NewReader(input), "main.opp", 5); err != nil {
+		t.Errorf("Solve(5) error = %v, want nil: the broken block is closed", err)
+	}
+
+	_, err := Solve(strings.NewReader(input), "main.opp", 2)
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 2 {
+		t.Fatalf("Solve(2) error = %v, want the syntax error and the stray ##.", err)
+	}
+	if diagnostics[0].Pos.Line != 1 || diagnostics[1].Pos.Line != 7 {
+		t.Errorf("Solve(2) diagnostics = %v", diagnostics)
+	}
+
+	if _, err := Solve(strings.NewReader(input), "main.opp", 8); err == nil || !strings.Contains(err.Error(), "past the end") {
+		t.Errorf("Solve(8) error = %v", err)
+	}
+}
\ No newline at end of file
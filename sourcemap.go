@@ -0,0 +1,256 @@
+package opp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// SourceMap maps every output line back to the input. It marshals to JSON
+// in the Source Map v3 format, except that columns count bytes rather than
+// UTF-16 code units.
+type SourceMap struct {
+	// File is the name of the generated file, if known
+	File string
+	// Lines holds the mapping segments of each output line, in order
+	Lines [][]Segment
+}
+
+// Segment maps the output bytes starting at Column up to the next segment
+// of the same line
+type Segment struct {
+	// Column is the 0-based byte column in the output line
+	Column int
+	// Source is the input position the bytes came from
+	Source Position
+	// Name is the outermost macro whose expansion produced the bytes, or
+	// empty for text copied from the input
+	Name string
+}
+
+// ProcessWithSourceMap works like Process and also returns a source map for
+// the output
+func (p *Preprocessor) ProcessWithSourceMap(input string) (string, *SourceMap, error) {
+	sourceMap := &SourceMap{}
+	result, err := p.processToString(context.Background(), strings.NewReader(input), sourceMap)
+	return result, sourceMap, err
+}
+
+// ProcessFileWithSourceMap works like ProcessFile and also returns a source
+// map for the output
+func (p *Preprocessor) ProcessFileWithSourceMap(filename string) (string, *SourceMap, error) {
+	f, err := p.openSourceFile(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	
+	sourceMap := &SourceMap{}
+	result, err := p.processToString(context.Background(), f, sourceMap)
+	return result, sourceMap, err
+}
+
+// MarshalJSON encodes the map in Source Map v3 format
+func (m *SourceMap) MarshalJSON() ([]byte, error) {
+	v3 := struct {
+		Version  int      `json:"version"`
+		File     string   `json:"file,omitempty"`
+		Sources  []string `json:"sources"`
+		Names    []string `json:"names"`
+		Mappings string   `json:"mappings"`
+	}{
+		Version: 3,
+		File:    m.File,
+		Sources: []string{},
+		Names:   []string{},
+	}
+	
+	sources := map[string]int{}
+	names := map[string]int{}
+	index := func(table map[string]int, list *[]string, s string) int {
+		if i, ok := table[s]; ok {
+			return i
+		}
+		table[s] = len(*list)
+		*list = append(*list, s)
+		return table[s]
+	}
+	
+	// Fields other than the generated column are relative to the previous
+	// segment anywhere in the map
+	var mappings strings.Builder
+	prevSource, prevLine, prevColumn, prevName := 0, 0, 0, 0
+	for i, segments := range m.Lines {
+		if i > 0 {
+			mappings.WriteByte(';')
+		}
+		prevGenerated := 0
+		for j, seg := range segments {
+			if j > 0 {
+				mappings.WriteByte(',')
+			}
+			source := index(sources, &v3.Sources, seg.Source.File)
+			line := seg.Source.Line - 1
+			column := max(seg.Source.Column-1, 0)
+			
+			writeVLQ(&mappings, seg.Column-prevGenerated)
+			writeVLQ(&mappings, source-prevSource)
+			writeVLQ(&mappings, line-prevLine)
+			writeVLQ(&mappings, column-prevColumn)
+			prevGenerated, prevSource, prevLine, prevColumn = seg.Column, source, line, column
+			
+			if seg.Name != "" {
+				name := index(names, &v3.Names, seg.Name)
+				writeVLQ(&mappings, name-prevName)
+				prevName = name
+			}
+		}
+	}
+	v3.Mappings = mappings.String()
+	
+	return json.Marshal(v3)
+}
+
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// writeVLQ appends n as a base64 variable-length quantity
+func writeVLQ(b *strings.Builder, n int) {
+	v := n << 1
+	if n < 0 {
+		v = (-n << 1) | 1
+	}
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		b.WriteByte(base64Digits[digit])
+		if v == 0 {
+			return
+		}
+	}
+}
+
+// origin records where a byte of a processed line came from
+type origin struct {
+	// column is the 0-based byte offset in the source line
+	column int
+	// name is the outermost macro expansion that produced the byte, or
+	// empty for text copied from the source
+	name string
+	// hidden holds the macros whose expansion produced the byte; they are
+	// not expanded again when the byte is rescanned
+	hidden *hideSet
+	// literal is set for bytes of strings, characters and comments, which
+	// are never expanded
+	literal bool
+}
+
+// hideSet is an immutable set of macro names
+type hideSet struct {
+	name string
+	next *hideSet
+}
+
+func (h *hideSet) contains(name string) bool {
+	for ; h != nil; h = h.next {
+		if h.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// with returns the set extended by name
+func (h *hideSet) with(name string) *hideSet {
+	if h.contains(name) {
+		return h
+	}
+	return &hideSet{name: name, next: h}
+}
+
+// mappedLine is a processed line that remembers the origin of each byte
+type mappedLine struct {
+	text    []byte
+	origins []origin
+}
+
+// newMappedLine returns a source line whose bytes map to themselves
+func newMappedLine(line string) *mappedLine {
+	m := &mappedLine{text: []byte(line), origins: make([]origin, len(line))}
+	for i := range m.origins {
+		m.origins[i].column = i
+	}
+	return m
+}
+
+// expansionLine returns a line produced entirely by one expansion
+func expansionLine(text string, column int, name string) *mappedLine {
+	m := &mappedLine{}
+	m.appendExpansion(text, origin{column: column}, name)
+	return m
+}
+
+// uniformLine returns a line whose bytes all have origin at
+func uniformLine(text string, at origin) *mappedLine {
+	m := &mappedLine{text: []byte(text), origins: make([]origin, len(text))}
+	for i := range m.origins {
+		m.origins[i] = at
+	}
+	return m
+}
+
+func (m *mappedLine) String() string {
+	return string(m.text)
+}
+
+// appendSource copies src[start:end] together with its origins
+func (m *mappedLine) appendSource(src *mappedLine, start, end int) {
+	m.text = append(m.text, src.text[start:end]...)
+	m.origins = append(m.origins, src.origins[start:end]...)
+}
+
+// appendExpansion appends the expansion of macro name found at the source
+// byte with origin at. Text produced while rescanning an earlier expansion
+// stays attributed to that outer expansion, and name is hidden from
+// rescans of the appended text.
+func (m *mappedLine) appendExpansion(text string, at origin, name string) {
+	if at.name == "" {
+		at.name = name
+	}
+	at.hidden = at.hidden.with(name)
+	m.text = append(m.text, text...)
+	for i := 0; i < len(text); i++ {
+		m.origins = append(m.origins, at)
+	}
+}
+
+// protect marks the bytes of spans as literal; the spans are relative to
+// base
+func (m *mappedLine) protect(spans []Span, base int) {
+	for _, span := range spans {
+		for i := base + span.Start; i < base+span.End; i++ {
+			m.origins[i].literal = true
+		}
+	}
+}
+
+// segments compresses the per-byte origins into source map segments
+func (m *mappedLine) segments(file string, line int) []Segment {
+	var segments []Segment
+	for i, o := range m.origins {
+		if i > 0 {
+			prev := m.origins[i-1]
+			if o.name == prev.name && (o.name != "" && o.column == prev.column || o.name == "" && o.column == prev.column+1) {
+				continue
+			}
+		}
+		segments = append(segments, Segment{
+			Column: i,
+			Source: Position{File: file, Line: line, Column: o.column + 1},
+			Name:   o.name,
+		})
+	}
+	return segments
+}
\ No newline at end of file
@@ -0,0 +1,111 @@
+package opp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSourceMapSegments(t *testing.T) {
+	input := "##:FOO bar\n##:F(x) [#0]\nx FOO y\n\nF(FOO) ##_"
+
+	result, sm, err := New().ProcessWithSourceMap(input)
+	if err != nil {
+		t.Fatalf("ProcessWithSourceMap() error = %v", err)
+	}
+	if expected := "x bar y\n[bar] 0"; result != expected {
+		t.Fatalf("ProcessWithSourceMap() = %q, want %q", result, expected)
+	}
+
+	expected := [][]Segment{
+		{
+			{Column: 0, Source: Position{Line: 3, Column: 1}},
+			{Column: 2, Source: Position{Line: 3, Column: 3}, Name: "FOO"},
+			{Column: 5, Source: Position{Line: 3, Column: 6}},
+		},
+		{
+			{Column: 0, Source: Position{Line: 5, Column: 1}, Name: "F"},
+			{Column: 5, Source: Position{Line: 5, Column: 7}},
+			{Column: 6, Source: Position{Line: 5, Column: 8}, Name: "##_"},
+		},
+	}
+	if !reflect.DeepEqual(sm.Lines, expected) {
+		t.Errorf("Lines = %+v, want %+v", sm.Lines, expected)
+	}
+}
+
+func TestSourceMapJSON(t *testing.T) {
+	_, sm, err := New().ProcessWithSourceMap("##:FOO bar\nx FOO\n\ny")
+	if err != nil {
+		t.Fatalf("ProcessWithSourceMap() error = %v", err)
+	}
+	sm.File = "out.go"
+
+	data, err := json.Marshal(sm)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	expected := `{"version":3,"file":"out.go","sources":[""],"names":["FOO"],"mappings":"AACA,EAAEA;AAEF"}`
+	if string(data) != expected {
+		t.Errorf("json.Marshal() = %s, want %s", data, expected)
+	}
+}
+
+func TestSourceMapIncludes(t *testing.T) {
+	tempDir := t.TempDir()
+	header := filepath.Join(tempDir, "header.h")
+	if err := os.WriteFile(header, []byte("h1"), 0644); err != nil {
+		t.Fatalf("Failed to write header file: %v", err)
+	}
+	mainFile := filepath.Join(tempDir, "main.c")
+	if err := os.WriteFile(mainFile, []byte("a\n##<header\\.h.\nb"), 0644); err != nil {
+		t.Fatalf("Failed to write main file: %v", err)
+	}
+
+	p := New()
+	p.SetLineDirectives(LineDirectivesC)
+	_, sm, err := p.ProcessFileWithSourceMap(mainFile)
+	if err != nil {
+		t.Fatalf("ProcessFileWithSourceMap() error = %v", err)
+	}
+
+	var sources []string
+	for _, segments := range sm.Lines {
+		if len(segments) == 0 {
+			sources = append(sources, "")
+			continue
+		}
+		sources = append(sources, fmt.Sprintf("%s:%d", filepath.Base(segments[0].Source.File), segments[0].Source.Line))
+	}
+	expected := []string{"", "main.c:1", "", "header.h:1", "", "main.c:3"}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("line sources = %v, want %v", sources, expected)
+	}
+}
+
+func TestWriteVLQ(t *testing.T) {
+	tests := []struct {
+		n        int
+		expected string
+	}{
+		{0, "A"},
+		{1, "C"},
+		{-1, "D"},
+		{15, "e"},
+		{16, "gB"},
+		{-16, "hB"},
+		{123, "2H"},
+	}
+
+	for _, tt := range tests {
+		var b strings.Builder
+		writeVLQ(&b, tt.n)
+		if b.String() != tt.expected {
+			t.Errorf("writeVLQ(%d) = %q, want %q", tt.n, b.String(), tt.expected)
+		}
+	}
+}
\ No newline at end of file
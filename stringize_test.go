@@ -1,6 +1,7 @@
 package opp
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -107,6 +108,36 @@ func TestProcessStringizeCharize(t *testing.T) {
 			args:       []string{"42"},
 			expected:   `value: 42`,
 		},
+		{
+			name:       "multi-digit arg ref",
+			definition: `#10 #11`,
+			args:       []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			expected:   `k l`,
+		},
+		{
+			name:       "multi-digit stringize and charize",
+			definition: `#"#10 #'#11`,
+			args:       []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			expected:   `"k" 'l'`,
+		},
+		{
+			name:       "multi-digit varargs",
+			definition: `f(##10..n)`,
+			args:       []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			expected:   `f(k, l)`,
+		},
+		{
+			name:       "bracketed arg ref followed by a digit",
+			definition: `#[1]0 #"#[0]1 ##[1]..n`,
+			args:       []string{"x", "y"},
+			expected:   `y0 "x"1 y`,
+		},
+		{
+			name:       "unclosed bracket",
+			definition: `#[1 #[]`,
+			args:       []string{"x", "y"},
+			expected:   `#[1 #[]`,
+		},
 	}
 	
 	p := New()
@@ -234,4 +265,35 @@ MIX(var, value)`,
 			}
 		})
 	}
+}
+
+func TestMultiDigitArguments(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"twelve arguments", "##:LAST #11\nLAST(a,b,c,d,e,f,g,h,i,j,k,l)", "l"},
+		{"bracketed index makes a macro function-like", "##:TEN #[1]0\nTEN(a,b)", "b0"},
+		{"escaped index", "##:DEF ##:GET ##,#10 ##,#[1]0\nDEF", "##:GET #10 #[1]0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := New().Process(tt.input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+	
+	p := New()
+	if _, err := p.Process("##:LAST #11\nLAST(a,b)"); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if warnings := p.Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0].String(), "uses #11") {
+		t.Errorf("Warnings() = %v, want a warning about #11", warnings)
+	}
 }
\ No newline at end of file
@@ -156,6 +156,34 @@ test!`,
 				t.Fatalf("Process() error = %v", err)
 			}
 			
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDefineVariableAndMacro(t *testing.T) {
+	input := "##~(~DEBUG)\nif DEBUG {}\n##.\nVERSION"
+	tests := []struct {
+		name     string
+		define   func(p *Preprocessor)
+		expected string
+	}{
+		{"nothing", func(p *Preprocessor) {}, "VERSION"},
+		{"variable", func(p *Preprocessor) { p.DefineVariable("DEBUG") }, "if DEBUG {}\nVERSION"},
+		{"empty macro", func(p *Preprocessor) { p.DefineMacro("DEBUG", "") }, "if  {}\nVERSION"},
+		{"macro", func(p *Preprocessor) { p.DefineMacro("DEBUG", "true"); p.DefineMacro("VERSION", "2") }, "if true {}\n2"},
+		{"undefined again", func(p *Preprocessor) { p.DefineMacro("DEBUG", "true"); p.Undefine("DEBUG") }, "VERSION"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			tt.define(p)
+			result, err := p.Process(input)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
 			if result != tt.expected {
 				t.Errorf("Process() = %q, want %q", result, tt.expected)
 			}
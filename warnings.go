@@ -0,0 +1,141 @@
+package opp
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Warning identifies a class of suspicious but legal OPP constructs. The zero
+// value is used by diagnostics that are not warnings.
+type Warning int
+
+const (
+	// WarnRedefine: ##: redefines a macro that is already defined
+	WarnRedefine Warning = iota + 1
+	// WarnUndefineUnknown: ##- names a macro or variable that is not defined
+	WarnUndefineUnknown
+	// WarnMissingArguments: a function-like macro is called with fewer
+	// arguments than the highest #N its body refers to
+	WarnMissingArguments
+	// WarnUnusedMacro: a macro defined with ##: is never expanded or tested
+	WarnUnusedMacro
+	// WarnConstantCondition: a condition is always or never true; reported
+	// by Lint
+	WarnConstantCondition
+	// WarnUnreachableBranch: a ##@ branch is never taken, or only where an
+	// earlier branch of its block is; reported by Lint
+	WarnUnreachableBranch
+	// WarnDeadBlock: a branch contradicts the enclosing block; reported by
+	// Lint
+	WarnDeadBlock
+)
+
+var warningNames = map[Warning]string{
+	WarnRedefine:          "redefine",
+	WarnUndefineUnknown:   "undefine-unknown",
+	WarnMissingArguments:  "missing-args",
+	WarnUnusedMacro:       "unused-macro",
+	WarnConstantCondition: "constant-condition",
+	WarnUnreachableBranch: "unreachable-branch",
+	WarnDeadBlock:         "dead-block",
+}
+
+// AllWarnings lists every warning class
+var AllWarnings = []Warning{WarnRedefine, WarnUndefineUnknown, WarnMissingArguments, WarnUnusedMacro, WarnConstantCondition, WarnUnreachableBranch, WarnDeadBlock}
+
+func (w Warning) String() string {
+	if name, ok := warningNames[w]; ok {
+		return name
+	}
+	return fmt.Sprintf("Warning(%d)", int(w))
+}
+
+// ParseWarning returns the warning with the given name, as printed by String
+func ParseWarning(name string) (Warning, error) {
+	for w, n := range warningNames {
+		if n == name {
+			return w, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown warning %q", name)
+}
+
+// SetWarning enables or disables a warning class. All warnings are enabled
+// by default.
+func (p *Preprocessor) SetWarning(w Warning, enabled bool) {
+	p.disabledWarnings[w] = !enabled
+}
+
+// SetWarningsAsErrors turns every enabled warning into an error
+func (p *Preprocessor) SetWarningsAsErrors(on bool) {
+	p.warningsAsErrors = on
+}
+
+// Warnings returns the warnings recorded by the last call to Process
+func (p *Preprocessor) Warnings() Diagnostics {
+	var warnings Diagnostics
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityWarning {
+			warnings = append(warnings, d)
+		}
+	}
+	return warnings
+}
+
+// warn records a warning of class w. With warnings as errors it behaves like
+// any other error: the returned error is non-nil unless keep-going mode
+// recorded it.
+func (p *Preprocessor) warn(w Warning, err *Error) error {
+	if p.disabledWarnings[w] {
+		return nil
+	}
+	if p.warningsAsErrors {
+		if p.keepGoing {
+			p.diagnostics = append(p.diagnostics, &Diagnostic{Severity: SeverityError, Warning: w, Error: err})
+			return nil
+		}
+		return err
+	}
+	p.diagnostics = append(p.diagnostics, &Diagnostic{Severity: SeverityWarning, Warning: w, Error: err})
+	return nil
+}
+
+// warnUnused reports m if it was defined in the source and never used
+func (p *Preprocessor) warnUnused(m *Macro) error {
+	if m.used || m.pos.Line == 0 {
+		return nil
+	}
+	return p.warn(WarnUnusedMacro, &Error{
+		Pos:          m.pos,
+		Directive:    DirectiveDefine,
+		IncludeChain: m.includeChain,
+		Err:          fmt.Errorf("macro %s defined but never used", m.Name),
+	})
+}
+
+// warnUnusedMacros reports every macro still defined that was never used,
+// in order of definition
+func (p *Preprocessor) warnUnusedMacros() error {
+	var unused []*Macro
+	for _, m := range p.macros {
+		if !m.used && m.pos.Line > 0 {
+			unused = append(unused, m)
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool {
+		a, b := unused[i].pos, unused[j].pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	for _, m := range unused {
+		if err := p.warnUnused(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
\ No newline at end of file
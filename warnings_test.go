@@ -0,0 +1,143 @@
+package opp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		warnings []Warning
+		lines    []int
+	}{
+		{
+			name:     "redefinition",
+			input:    "##:A 1\nA\n##:A 2\nA",
+			warnings: []Warning{WarnRedefine},
+			lines:    []int{3},
+		},
+		{
+			name:     "redefinition before use",
+			input:    "##:A 1\n##:A 2\nA",
+			warnings: []Warning{WarnRedefine, WarnUnusedMacro},
+			lines:    []int{2, 1},
+		},
+		{
+			name:     "undefine unknown name",
+			input:    "##-NOPE",
+			warnings: []Warning{WarnUndefineUnknown},
+			lines:    []int{1},
+		},
+		{
+			name:     "undefine used macro",
+			input:    "##:A 1\nA\n##-A",
+			warnings: nil,
+		},
+		{
+			name:     "too few arguments",
+			input:    "##:F(a,b) #0+#1\nx = F(1)",
+			warnings: []Warning{WarnMissingArguments},
+			lines:    []int{2},
+		},
+		{
+			name:     "enough arguments and varargs",
+			input:    "##:F(a) #0(##1..n)\nF(f)\nF(f, 1, 2)",
+			warnings: nil,
+		},
+		{
+			name:     "unused macros in definition order",
+			input:    "##:B b\n##:A a\ntext",
+			warnings: []Warning{WarnUnusedMacro, WarnUnusedMacro},
+			lines:    []int{1, 2},
+		},
+		{
+			name:     "macro tested in a condition counts as used",
+			input:    "##:DEBUG 1\n##~DEBUG|~DEBUG\n##.",
+			warnings: nil,
+		},
+		{
+			name:     "macros defined through the API are not reported",
+			input:    "text",
+			warnings: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.Define("API", "value")
+			if _, err := p.Process(tt.input); err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+
+			got := p.Warnings()
+			if len(got) != len(tt.warnings) {
+				t.Fatalf("got warnings %v, want %v", got, tt.warnings)
+			}
+			for i, w := range got {
+				if w.Severity != SeverityWarning || w.Warning != tt.warnings[i] {
+					t.Errorf("warning %d = %v, want %v", i, w, tt.warnings[i])
+				}
+				if w.Pos.Line != tt.lines[i] {
+					t.Errorf("warning %d line = %d, want %d", i, w.Pos.Line, tt.lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDisableWarning(t *testing.T) {
+	p := New()
+	p.SetWarning(WarnUnusedMacro, false)
+	if _, err := p.Process("##:A 1\n##-B"); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	got := p.Warnings()
+	if len(got) != 1 || got[0].Warning != WarnUndefineUnknown {
+		t.Errorf("Warnings() = %v, want only %v", got, WarnUndefineUnknown)
+	}
+}
+
+func TestWarningsAsErrors(t *testing.T) {
+	p := New()
+	p.SetWarningsAsErrors(true)
+	_, err := p.Process("a\n##-B\nb")
+
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Pos.Line != 2 || perr.Directive != DirectiveUndefine {
+		t.Fatalf("Process() error = %v, want *Error for ##- on line 2", err)
+	}
+
+	p = New()
+	p.SetWarningsAsErrors(true)
+	p.SetKeepGoing(true)
+	result, err := p.Process("a\n##-B\n##-C\nb")
+
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 2 {
+		t.Fatalf("Process() error = %v, want two diagnostics", err)
+	}
+	for _, d := range diagnostics {
+		if d.Severity != SeverityError || d.Warning != WarnUndefineUnknown {
+			t.Errorf("diagnostic = %v, want error-severity %v", d, WarnUndefineUnknown)
+		}
+	}
+	if result != "a\nb" {
+		t.Errorf("Process() = %q, want %q", result, "a\nb")
+	}
+}
+
+func TestParseWarning(t *testing.T) {
+	for _, w := range AllWarnings {
+		got, err := ParseWarning(w.String())
+		if err != nil || got != w {
+			t.Errorf("ParseWarning(%q) = %v, %v", w.String(), got, err)
+		}
+	}
+	if _, err := ParseWarning("bogus"); err == nil {
+		t.Errorf("ParseWarning(bogus) succeeded")
+	}
+}
\ No newline at end of file